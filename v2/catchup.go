@@ -0,0 +1,208 @@
+package emitter
+
+import (
+	"bytes"
+	"context"
+	"iter"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Position represents the point of a channel from which a catch-up subscription replays
+// the stored messages. The zero position replays every stored message.
+type Position struct {
+	Cursor HistoryCursor // The message to resume after, if any
+	Time   time.Time     // The time to start from, if there is no cursor
+}
+
+// AfterCursor returns the position following the message designated by a cursor.
+func AfterCursor(cursor HistoryCursor) Position {
+	return Position{Cursor: cursor}
+}
+
+// Since returns the position of the first message stored at or after a point in time.
+func Since(t time.Time) Position {
+	return Position{Time: t}
+}
+
+// storedMessage represents a replayed message, which unlike a live message carries its
+// identifier.
+type storedMessage struct {
+	HistoryMessage
+}
+
+// Topic returns the channel of the message.
+func (m *storedMessage) Topic() string {
+	return m.Channel
+}
+
+// Payload returns the payload of the message.
+func (m *storedMessage) Payload() []byte {
+	return m.HistoryMessage.Payload
+}
+
+// CursorOf returns the cursor of a message replayed by a catch-up subscription. Live
+// messages do not carry their identifier, in which case it returns false.
+func CursorOf(m Message) (HistoryCursor, bool) {
+	if stored, ok := m.(*storedMessage); ok {
+		return stored.Cursor(), true
+	}
+	return "", false
+}
+
+// ------------------------------------------------------------------------------------
+
+// catchUp buffers the live messages of a subscription while the stored ones are being
+// replayed.
+type catchUp struct {
+	sync.Mutex
+	live   bool      // Whether the live messages are delivered as they arrive
+	buffer []Message // The live messages received during the replay
+}
+
+// SubscribeFrom subscribes a handler to a channel and replays the messages stored from a
+// position before delivering the live ones, in order. It subscribes first, buffers the
+// live messages while the stored ones are replayed, then delivers the buffered messages
+// and switches to live delivery. It returns once the replay is over, the handler having
+// been called for every replayed message. Use CursorOf to retrieve the cursor of a
+// replayed message.
+//
+// The messages stored before subscribing are replayed as they are retrieved. The live
+// messages do not carry their identifier, so the messages stored while subscribing,
+// which may also have been received live, are recognized by their channel and payload.
+// Their delivery is at most once: one whose channel and payload are the same as those
+// of a later live message may be taken for it and not be replayed.
+func (c *Client) SubscribeFrom(ctx context.Context, key, channel string, from Position, handler MessageHandler, options ...Option) (*Subscription, error) {
+	q := c.HistoryQuery(key, channel)
+	if !from.Time.IsZero() {
+		q = q.From(from.Time)
+	}
+
+	// The messages stored before subscribing cannot have been received live
+	newest, err := first(q.Limit(1).PageSize(1).Messages(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	cu := new(catchUp)
+	sub, err := c.subscribe(ctx, &subscription{
+		Key:     key,
+		Channel: channel,
+		Options: withoutHistory(options),
+	}, func(c *Client, m Message) {
+		cu.Lock()
+		if !cu.live {
+			cu.buffer = append(cu.buffer, m)
+			cu.Unlock()
+			return
+		}
+
+		cu.Unlock()
+		handler(c, m)
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.replay(ctx, q, from.Cursor, newest, handler); err != nil {
+		sub.Unsubscribe()
+		return nil, err
+	}
+
+	// Retrieve the messages stored while subscribing, down to the newest one before
+	stop := newest.ID
+	if stop == nil && from.Cursor != "" {
+		stop, _ = from.Cursor.id()
+	}
+
+	var stored []HistoryMessage // From the most recent
+	for m, err := range q.Messages(ctx) {
+		if err != nil {
+			sub.Unsubscribe()
+			return nil, err
+		}
+
+		if stop != nil && bytes.Equal(m.ID, stop) {
+			break
+		}
+		stored = append(stored, m)
+	}
+	slices.Reverse(stored)
+
+	// Replay the stored messages which were not received live
+	cu.Lock()
+	stored = stored[:len(stored)-overlap(stored, cu.buffer)]
+	cu.Unlock()
+	for i := range stored {
+		c.invoke(handler, &storedMessage{stored[i]})
+	}
+
+	// Deliver the buffered messages until none is left, then switch to live delivery
+	for {
+		cu.Lock()
+		buffered := cu.buffer
+		cu.buffer = nil
+		cu.live = len(buffered) == 0
+		cu.Unlock()
+		if len(buffered) == 0 {
+			return sub, nil
+		}
+
+		for _, m := range buffered {
+			c.deliver(handler, m)
+		}
+	}
+}
+
+// replay replays the messages stored from a position up to the newest one stored before
+// subscribing, as they are retrieved.
+func (c *Client) replay(ctx context.Context, q HistoryQuery, cursor HistoryCursor, newest HistoryMessage, handler MessageHandler) error {
+	if newest.ID == nil || newest.Cursor() == cursor {
+		return nil // Nothing was stored after the position
+	}
+
+	if cursor != "" {
+		q = q.After(cursor)
+	}
+
+	for m, err := range q.Order(OldestFirst).stopBefore(newest.ID).Messages(ctx) {
+		if err != nil {
+			return err
+		}
+		c.invoke(handler, &storedMessage{m})
+	}
+
+	c.invoke(handler, &storedMessage{newest})
+	return nil
+}
+
+// first returns the first message of an iteration, or an empty message if there is none.
+func first(messages iter.Seq2[HistoryMessage, error]) (HistoryMessage, error) {
+	for m, err := range messages {
+		return m, err
+	}
+	return HistoryMessage{}, nil
+}
+
+// deliver calls a handler for a message which already went through the middleware,
+// recovering from a panic.
+func (c *Client) deliver(handler MessageHandler, m Message) {
+	defer c.recoverHandler(m.Topic(), m.Payload())
+	handler(c, m)
+}
+
+// overlap returns the number of stored messages, at the end of the list, which were also
+// received live. These form the longest suffix of the stored messages which is a
+// subsequence of the live ones, as the live messages which were not stored are missing
+// from the history.
+func overlap(stored []HistoryMessage, live []Message) int {
+	i, j := len(stored)-1, len(live)-1
+	for i >= 0 && j >= 0 {
+		if stored[i].Channel == live[j].Topic() && bytes.Equal(stored[i].Payload, live[j].Payload()) {
+			i--
+		}
+		j--
+	}
+	return len(stored) - 1 - i
+}
@@ -0,0 +1,154 @@
+package emitter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribeFrom(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, c.Publish("key", "catchup/", fmt.Sprintf("%d", i), WithTTL(60)))
+	}
+
+	ctx := context.Background()
+	var cursor HistoryCursor
+	for m, err := range c.HistoryQuery("key", "catchup/").Limit(1).Order(OldestFirst).Messages(ctx) {
+		assert.NoError(t, err)
+		cursor = m.Cursor() // The message "1"
+	}
+
+	var mu sync.Mutex
+	var received []string
+	var replayed int
+	sub, err := c.SubscribeFrom(ctx, "key", "catchup/", AfterCursor(cursor), func(_ *Client, m Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, string(m.Payload()))
+		if _, ok := CursorOf(m); ok {
+			replayed++
+		}
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2", "3"}, received)
+	assert.Equal(t, 2, replayed)
+
+	// Then the live messages are delivered
+	assert.NoError(t, c.Publish("key", "catchup/", "4"))
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 3
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"2", "3", "4"}, received)
+	assert.NoError(t, sub.Unsubscribe())
+}
+
+func TestSubscribeFromTime(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	assert.NoError(t, c.Publish("key", "catchup/", "1", WithTTL(60)))
+
+	received := make(chan string, 10)
+	_, err = c.SubscribeFrom(context.Background(), "key", "catchup/", Since(time.Now().Add(-time.Minute)), func(_ *Client, m Message) {
+		received <- string(m.Payload())
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", receive(t, received))
+
+	_, err = c.SubscribeFrom(context.Background(), "key", "catchup/", Since(time.Now().Add(time.Minute)), func(*Client, Message) {})
+	assert.NoError(t, err)
+}
+
+func TestSubscribeFromPages(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	const count = 2*defaultPageSize + 1
+	for i := 0; i < count; i++ {
+		assert.NoError(t, c.Publish("key", "catchup/", strconv.Itoa(i), WithTTL(60)))
+	}
+
+	// The stored messages are replayed in order across the pages
+	var received []string
+	_, err = c.SubscribeFrom(context.Background(), "key", "catchup/", Position{}, func(_ *Client, m Message) {
+		received = append(received, string(m.Payload()))
+	})
+	assert.NoError(t, err)
+	assert.Len(t, received, count)
+	for i := range received {
+		assert.Equal(t, strconv.Itoa(i), received[i])
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	stored := func(payloads ...string) (list []HistoryMessage) {
+		for _, p := range payloads {
+			list = append(list, HistoryMessage{Channel: "a/", Payload: []byte(p)})
+		}
+		return
+	}
+
+	live := func(payloads ...string) (list []Message) {
+		for _, p := range payloads {
+			list = append(list, &message{topic: "a/", payload: p})
+		}
+		return
+	}
+
+	assert.Equal(t, 0, overlap(stored("1", "2"), nil))
+	assert.Equal(t, 0, overlap(nil, live("1")))
+	assert.Equal(t, 2, overlap(stored("1", "2", "3"), live("2", "3", "4")))
+	assert.Equal(t, 2, overlap(stored("1", "2", "3"), live("2", "x", "3")))
+	assert.Equal(t, 0, overlap(stored("1", "2"), live("3")))
+
+	// A repeated payload is matched once per live message
+	assert.Equal(t, 1, overlap(stored("hb", "hb"), live("hb")))
+	assert.Equal(t, 2, overlap(stored("hb", "hb", "hb"), live("hb", "hb")))
+}
+
+func TestSubscribeFromDuplicates(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, c.Publish("key", "catchup/", "ok", WithTTL(60)))
+	}
+
+	received := make(chan string, 10)
+	_, err = c.SubscribeFrom(context.Background(), "key", "catchup/", Position{}, func(_ *Client, m Message) {
+		received <- string(m.Payload())
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, c.Publish("key", "catchup/", "ok", WithTTL(60)))
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "ok", receive(t, received))
+	}
+}
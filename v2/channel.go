@@ -0,0 +1,236 @@
+package emitter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The limits of a topic.
+const (
+	maxKeyLength   = 32    // The length of an emitter key
+	maxTopicLength = 65535 // The maximum length of an MQTT topic, in bytes
+)
+
+// Channel represents an emitter topic: the key authorizing the operation, the segments
+// of the channel, the share group of a shared subscription and the options.
+type Channel struct {
+	Key     string   // The key used to publish or subscribe, if any
+	Path    []string // The segments of the channel, such as "a", "+" or "#"
+	Group   string   // The share group, if any
+	Options []Option // The options of the operation
+}
+
+// NewChannel starts building a channel from its segments, each of which may contain
+// several segments separated by slashes.
+func NewChannel(path ...string) Channel {
+	var ch Channel
+	for _, p := range path {
+		ch.Path = append(ch.Path, splitPath(p)...)
+	}
+	return ch
+}
+
+// ParseTopic parses and validates a topic of the form "key/channel/?options" or
+// "key/$share/group/channel/?options".
+func ParseTopic(topic string) (Channel, error) {
+	var ch Channel
+	if i := strings.IndexByte(topic, '?'); i >= 0 {
+		for _, o := range strings.Split(topic[i+1:], "&") {
+			ch.Options = append(ch.Options, option(o))
+		}
+		topic = topic[:i]
+	}
+
+	parts := splitPath(topic)
+	if len(parts) < 2 {
+		return ch, fmt.Errorf("%w, '%s' must contain a key and a channel", ErrInvalidChannel, topic)
+	}
+
+	ch.Key, parts = parts[0], parts[1:]
+	if parts[0] == "$share" {
+		if len(parts) < 3 {
+			return ch, fmt.Errorf("%w, '%s' must contain a share group and a channel", ErrInvalidChannel, topic)
+		}
+
+		ch.Group, parts = parts[1], parts[2:]
+	}
+
+	ch.Path = parts
+	return ch, ch.Validate()
+}
+
+// WithKey returns a copy of the channel with the key provided.
+func (ch Channel) WithKey(key string) Channel {
+	ch.Key = trim(key)
+	return ch
+}
+
+// WithGroup returns a copy of the channel with the share group provided.
+func (ch Channel) WithGroup(group string) Channel {
+	ch.Group = trim(group)
+	return ch
+}
+
+// WithOptions returns a copy of the channel with the options provided appended.
+func (ch Channel) WithOptions(options ...Option) Channel {
+	ch.Options = append(append([]Option{}, ch.Options...), options...)
+	return ch
+}
+
+// Name returns the channel, without the key and the options, such as "a/b/".
+func (ch Channel) Name() string {
+	name := strings.Join(ch.Path, "/")
+	if ch.hasTrailingWildcard() {
+		return name // https://github.com/eclipse/paho.mqtt.golang/blob/master/topic.go#L78
+	}
+	return name + "/"
+}
+
+// String formats the topic of the channel.
+func (ch Channel) String() string {
+	var sb strings.Builder
+	if ch.Key != "" {
+		sb.WriteString(ch.Key)
+		sb.WriteByte('/')
+	}
+
+	if ch.Group != "" {
+		sb.WriteString("$share/")
+		sb.WriteString(ch.Group)
+		sb.WriteByte('/')
+	}
+
+	sb.WriteString(ch.Name())
+	sb.WriteString(formatOptions(ch.Options))
+	return sb.String()
+}
+
+// IsWildcard checks whether the channel contains a wildcard, in which case it can only
+// be subscribed to.
+func (ch Channel) IsWildcard() bool {
+	for _, segment := range ch.Path {
+		if segment == "+" || segment == "#" {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the key, the segments, the share group and the options of the channel,
+// returning an error wrapping ErrInvalidChannel which describes the first problem found.
+func (ch Channel) Validate() error {
+	for _, c := range ch.Key {
+		if !isKeyChar(c) {
+			return fmt.Errorf("%w, the key contains the character '%c'", ErrInvalidChannel, c)
+		}
+	}
+
+	if n := len(ch.Key); n > maxKeyLength {
+		return fmt.Errorf("%w, the key is %d characters long, the limit is %d", ErrInvalidChannel, n, maxKeyLength)
+	}
+
+	if len(ch.Path) == 0 {
+		return fmt.Errorf("%w, the channel is empty", ErrInvalidChannel)
+	}
+
+	for i, segment := range ch.Path {
+		switch {
+		case segment == "":
+			return fmt.Errorf("%w, segment %d is empty", ErrInvalidChannel, i+1)
+		case segment == "+":
+		case segment == "#" && i == len(ch.Path)-1:
+		case segment == "#":
+			return fmt.Errorf("%w, the wildcard '#' must be the last segment", ErrInvalidChannel)
+		default:
+			if err := validateWord("segment "+strconv.Itoa(i+1), segment); err != nil {
+				return err
+			}
+		}
+	}
+
+	if ch.Group != "" {
+		if err := validateWord("the share group", ch.Group); err != nil {
+			return err
+		}
+	}
+
+	for _, o := range ch.Options {
+		if err := validateOption(o); err != nil {
+			return err
+		}
+	}
+
+	if n := len(ch.String()); n > maxTopicLength {
+		return fmt.Errorf("%w, the topic is %d bytes long, the limit is %d", ErrInvalidChannel, n, maxTopicLength)
+	}
+	return nil
+}
+
+// hasTrailingWildcard checks whether the last segment is the multi-level wildcard.
+func (ch Channel) hasTrailingWildcard() bool {
+	n := len(ch.Path)
+	return n > 0 && ch.Path[n-1] == "#"
+}
+
+// validateWord checks the characters of a segment or a share group, which are letters,
+// digits, '-', '_', '.' and ':'.
+func validateWord(what, word string) error {
+	for i := 0; i < len(word); i++ {
+		if c := word[i]; !isWordChar(c) {
+			return fmt.Errorf("%w, %s '%s' contains the character '%c'", ErrInvalidChannel, what, word, c)
+		}
+	}
+	return nil
+}
+
+// isWordChar checks whether a character is allowed in a segment or a share group.
+func isWordChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	case c == '-', c == '_', c == '.', c == ':':
+		return true
+	default:
+		return false
+	}
+}
+
+// validateOption checks the name and the value of an option.
+func validateOption(o Option) error {
+	text := o.String()
+	if strings.HasPrefix(text, "+") {
+		return nil // Reserved
+	}
+
+	name, value, _ := strings.Cut(text, "=")
+	n, err := strconv.ParseInt(value, 10, 64)
+	switch name {
+	case "ttl":
+		if err != nil || n <= 0 {
+			return fmt.Errorf("%w, the option '%s' must be a positive number of seconds", ErrInvalidChannel, text)
+		}
+	case "last", "from", "until":
+		if err != nil || n < 0 {
+			return fmt.Errorf("%w, the option '%s' must be a non-negative number", ErrInvalidChannel, text)
+		}
+	case "me":
+		if value != "0" && value != "1" {
+			return fmt.Errorf("%w, the option '%s' must be 0 or 1", ErrInvalidChannel, text)
+		}
+	default:
+		return fmt.Errorf("%w, the option '%s' is not supported", ErrInvalidChannel, text)
+	}
+	return nil
+}
+
+// isKeyChar checks whether a character may appear in a key, which is encoded in the
+// URL-safe base64 alphabet.
+func isKeyChar(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_'
+}
+
+// splitPath splits a channel into its segments.
+func splitPath(channel string) []string {
+	return strings.Split(trim(channel), "/")
+}
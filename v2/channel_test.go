@@ -0,0 +1,79 @@
+package emitter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelString(t *testing.T) {
+	tests := []struct {
+		channel Channel
+		result  string
+	}{
+		{channel: NewChannel("a/b/c"), result: "a/b/c/"},
+		{channel: NewChannel("a", "b/c/").WithKey("key"), result: "key/a/b/c/"},
+		{channel: NewChannel("a/#/").WithKey("key").WithOptions(WithLast(5)), result: "key/a/#?last=5"},
+		{channel: NewChannel("a/+/c").WithKey("key").WithGroup("g"), result: "key/$share/g/a/+/c/"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.result, tc.channel.String())
+		assert.NoError(t, tc.channel.Validate())
+	}
+}
+
+func TestParseTopic(t *testing.T) {
+	ch, err := ParseTopic("key/$share/g/a/b/?me=0&last=10")
+	assert.NoError(t, err)
+	assert.Equal(t, "key", ch.Key)
+	assert.Equal(t, "g", ch.Group)
+	assert.Equal(t, []string{"a", "b"}, ch.Path)
+	assert.Equal(t, "a/b/", ch.Name())
+	assert.Equal(t, "key/$share/g/a/b/?me=0&last=10", ch.String())
+
+	ch, err = ParseTopic("0123456789abcdef0123456789abcdef/a_b/c-d/e.f/g:h/")
+	assert.NoError(t, err)
+	assert.Equal(t, "a_b/c-d/e.f/g:h/", ch.Name())
+
+	ch, err = ParseTopic("key/a/+/#")
+	assert.NoError(t, err)
+	assert.True(t, ch.IsWildcard())
+
+	for _, topic := range []string{
+		"key/",
+		"key/$share/g/",
+		"key/a//b/",
+		"key/a/#/b/",
+		"key/a b/",
+		"key/a+/",
+		"k.y/a/",
+		"key/$share/g!/a/",
+		"key/a[b]/",
+		"key/a\\b/",
+		"key/a^b/",
+		"key/a`b/",
+		"0123456789abcdef0123456789abcdef0/a/",
+		"key/a/?ttl=0",
+		"key/a/?last=x",
+		"key/a/?me=2",
+		"key/a/?foo=1",
+	} {
+		_, err := ParseTopic(topic)
+		assert.True(t, errors.Is(err, ErrInvalidChannel), topic)
+	}
+}
+
+func TestChannelValidateMessage(t *testing.T) {
+	err := NewChannel("a/b c/").Validate()
+	assert.EqualError(t, err, "emitter: the channel is not valid, segment 2 'b c' contains the character ' '")
+}
+
+func TestInvalidChannelFailsLocally(t *testing.T) {
+	c := NewClient()
+	assert.ErrorIs(t, c.Publish("key", "a/+/", "hello"), ErrInvalidChannel)
+	assert.ErrorIs(t, c.Publish("key", "a/b c/", "hello"), ErrInvalidChannel)
+	assert.ErrorIs(t, c.Subscribe("key", "a/#/b/", nil), ErrInvalidChannel)
+	assert.Len(t, c.handlers.Lookup("a/#/b/"), 0)
+}
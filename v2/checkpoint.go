@@ -0,0 +1,113 @@
+package emitter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Checkpointer stores the position of a durable consumer in each channel, so that it can
+// resume from there once restarted.
+type Checkpointer interface {
+
+	// Load returns the cursor of the last message processed in a channel, or an empty
+	// cursor if none was.
+	Load(ctx context.Context, channel string) (HistoryCursor, error)
+
+	// Save records the cursor of the last message processed in a channel.
+	Save(ctx context.Context, channel string, cursor HistoryCursor) error
+}
+
+// FileCheckpointer is a checkpointer which stores the cursors in a JSON file, replaced
+// atomically on every save.
+type FileCheckpointer struct {
+	sync.Mutex
+	path    string                   // The path of the file
+	cursors map[string]HistoryCursor // The cursors, by channel, nil until loaded
+}
+
+// NewFileCheckpointer creates a checkpointer which stores the cursors in the file
+// provided, created on the first save.
+func NewFileCheckpointer(path string) *FileCheckpointer {
+	return &FileCheckpointer{path: path}
+}
+
+// Load returns the cursor of the last message processed in a channel.
+func (f *FileCheckpointer) Load(_ context.Context, channel string) (HistoryCursor, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if err := f.read(); err != nil {
+		return "", err
+	}
+	return f.cursors[channel], nil
+}
+
+// Save records the cursor of the last message processed in a channel.
+func (f *FileCheckpointer) Save(_ context.Context, channel string, cursor HistoryCursor) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if err := f.read(); err != nil {
+		return err
+	}
+
+	f.cursors[channel] = cursor
+	data, err := json.Marshal(f.cursors)
+	if err != nil {
+		return err
+	}
+
+	// Write a temporary file and rename it, so that the file is never partially written
+	temp := f.path + ".tmp"
+	if err := writeSync(temp, data); err != nil {
+		return err
+	}
+	return os.Rename(temp, f.path)
+}
+
+// writeSync writes a file and flushes it to the disk before closing it, so that it is
+// complete once renamed even if the system crashes.
+func writeSync(path string, data []byte) error {
+	file, err := os.OpenFile(filepath.Clean(path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// read loads the cursors from the file, once. The checkpointer must be locked.
+func (f *FileCheckpointer) read() error {
+	if f.cursors != nil {
+		return nil
+	}
+
+	cursors := make(map[string]HistoryCursor)
+	data, err := os.ReadFile(filepath.Clean(f.path))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &cursors); err != nil {
+			return err
+		}
+	}
+
+	f.cursors = cursors
+	return nil
+}
@@ -0,0 +1,44 @@
+package emitter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCheckpointer(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	f := NewFileCheckpointer(path)
+	cursor, err := f.Load(ctx, "a/")
+	assert.NoError(t, err)
+	assert.Empty(t, cursor)
+
+	assert.NoError(t, f.Save(ctx, "a/", "AAE"))
+	assert.NoError(t, f.Save(ctx, "b/", "AAI"))
+	assert.NoError(t, f.Save(ctx, "a/", "AAM"))
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	// A new checkpointer reads the cursors saved
+	restarted := NewFileCheckpointer(path)
+	cursor, err = restarted.Load(ctx, "a/")
+	assert.NoError(t, err)
+	assert.Equal(t, HistoryCursor("AAM"), cursor)
+	cursor, err = restarted.Load(ctx, "b/")
+	assert.NoError(t, err)
+	assert.Equal(t, HistoryCursor("AAI"), cursor)
+
+	// A corrupted file is reported
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err = NewFileCheckpointer(path).Load(ctx, "a/")
+	assert.Error(t, err)
+
+	// A file which cannot be written is reported
+	missing := NewFileCheckpointer(filepath.Join(t.TempDir(), "missing", "checkpoints.json"))
+	assert.Error(t, missing.Save(ctx, "a/", "AAE"))
+}
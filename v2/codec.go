@@ -0,0 +1,73 @@
+package emitter
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec encodes and decodes the payloads of the messages.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Various built-in codecs
+var (
+	JSON Codec = jsonCodec{} // Encodes the payloads as JSON documents
+	Gob  Codec = gobCodec{}  // Encodes the payloads with encoding/gob
+	Raw  Codec = rawCodec{}  // Passes the payloads through, as strings or slices of bytes
+)
+
+// jsonCodec encodes the payloads as JSON documents.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// gobCodec encodes the payloads with encoding/gob.
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// rawCodec passes the payloads through, as strings or slices of bytes.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch p := v.(type) {
+	case []byte:
+		return p, nil
+	case string:
+		return []byte(p), nil
+	default:
+		return nil, fmt.Errorf("emitter: unable to encode %T as a raw payload", v)
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch p := v.(type) {
+	case *[]byte:
+		*p = append((*p)[:0], data...)
+	case *string:
+		*p = string(data)
+	default:
+		return fmt.Errorf("emitter: unable to decode a raw payload into %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package emitter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type point struct {
+	X, Y int
+}
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []Codec{JSON, Gob} {
+		b, err := codec.Marshal(point{1, 2})
+		assert.NoError(t, err)
+
+		var p point
+		assert.NoError(t, codec.Unmarshal(b, &p))
+		assert.Equal(t, point{1, 2}, p)
+	}
+}
+
+func TestRawCodec(t *testing.T) {
+	b, err := Raw.Marshal("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), b)
+
+	var s string
+	assert.NoError(t, Raw.Unmarshal(b, &s))
+	assert.Equal(t, "hello", s)
+
+	var out []byte
+	assert.NoError(t, Raw.Unmarshal(b, &out))
+	assert.Equal(t, b, out)
+
+	_, err = Raw.Marshal(42)
+	assert.Error(t, err)
+	assert.Error(t, Raw.Unmarshal(b, new(int)))
+}
@@ -0,0 +1,308 @@
+package emitter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errNotProcessed is returned by a catch-up which stopped at a message the handler failed
+// to process, the failure being already reported to the failure handler.
+var errNotProcessed = errors.New("emitter: the handler failed to process a message")
+
+// The policy under which the failed catch-ups are retried, unless a retry policy is set.
+var defaultConsumeRetry = &Backoff{Min: time.Second, Max: time.Minute, Jitter: 0.2}
+
+// Consumer is a durable consumer of the messages stored in a channel. It processes them
+// in order, records the cursor of each message once the handler returns successfully and
+// resumes from the last one recorded once restarted or reconnected.
+type Consumer struct {
+	client      *Client            // The client which consumes the channel
+	key         string             // The key of the channel
+	channel     string             // The channel consumed
+	checkpoints Checkpointer       // The store of the cursors
+	handler     ContextHandler     // The handler of the messages
+	sub         *Subscription      // The live subscription, which signals new messages
+	mu          sync.Mutex         // Serializes the catch-ups
+	cursor      HistoryCursor      // The cursor of the last message processed
+	wake        chan struct{}      // Signals that new messages may be stored
+	ctx         context.Context    // The context of the catch-ups, cancelled on close
+	cancel      context.CancelFunc // Cancels the catch-ups
+	stopped     chan struct{}      // Closed once the consumer stopped
+	handling    atomic.Uint64      // The goroutine calling the handler, if any
+}
+
+// Consume starts a durable consumer of the messages stored in a channel, which resumes
+// after the cursor recorded by the checkpointer, or from the oldest message stored. The
+// messages stored in the meantime are processed before it returns. Then, it processes
+// the new messages whenever one is received live and after the client reconnects.
+//
+// Only the stored messages are consumed, so they must be published with a TTL. A message
+// for which the handler returns an error is reported to the failure handler, and is
+// processed again after a delay decided by the retry policy, or by an exponential
+// backoff if none is set. The new messages received meanwhile do not hasten the retry.
+// If the consumer is closed by the handler before it returns, it returns
+// ErrConsumerClosed.
+func (c *Client) Consume(ctx context.Context, key, channel string, checkpoints Checkpointer, handler ContextHandler) (*Consumer, error) {
+	channel = NewChannel(channel).Name()
+	cursor, err := checkpoints.Load(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := &Consumer{
+		client:      c,
+		key:         key,
+		channel:     channel,
+		checkpoints: checkpoints,
+		handler:     handler,
+		cursor:      cursor,
+		wake:        make(chan struct{}, 1),
+		stopped:     make(chan struct{}),
+	}
+
+	// Subscribe first, so that no message is stored unnoticed during the catch-up
+	cs.sub, err = c.SubscribeHandle(ctx, key, channel, func(*Client, Message) {
+		cs.notify()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Register the consumer before the catch-up, so that the handler may close it
+	cs.ctx, cs.cancel = context.WithCancel(context.Background())
+	c.consumers.Add(cs)
+	err = cs.catchUpFirst(ctx)
+	switch {
+	case cs.ctx.Err() != nil:
+		close(cs.stopped)
+		return nil, ErrConsumerClosed
+	case err != nil && !errors.Is(err, errNotProcessed):
+		cs.client.consumers.Remove(cs)
+		cs.cancel()
+		cs.sub.Unsubscribe()
+		return nil, err
+	}
+
+	go cs.run(err)
+	return cs, nil
+}
+
+// catchUpFirst runs the first catch-up, which stops once either the context provided is
+// done or the consumer is closed.
+func (cs *Consumer) catchUpFirst(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(cs.ctx, cancel)
+	defer stop()
+	return cs.catchUp(ctx)
+}
+
+// Channel returns the channel consumed.
+func (cs *Consumer) Channel() string {
+	return cs.channel
+}
+
+// Cursor returns the cursor of the last message processed, empty if none was.
+func (cs *Consumer) Cursor() HistoryCursor {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.cursor
+}
+
+// Close stops the consumer and unsubscribes from the channel. Once it returns, no
+// message is processed and no cursor is recorded anymore, as it waits for the message
+// being processed if any. When called from the handler, it does not wait for the
+// handler to return, and the consumer stops right after.
+func (cs *Consumer) Close() error {
+	if !cs.client.consumers.Remove(cs) {
+		return nil // Already closed
+	}
+
+	cs.cancel()
+	if id := cs.handling.Load(); id == 0 || id != goroutineID() {
+		<-cs.stopped
+	}
+	return cs.sub.Unsubscribe()
+}
+
+// watching returns the channel consumed.
+func (cs *Consumer) watching() string {
+	return cs.channel
+}
+
+// notify signals that new messages may be stored, without blocking.
+func (cs *Consumer) notify() {
+	select {
+	case cs.wake <- struct{}{}:
+	default: // A catch-up is already pending
+	}
+}
+
+// run catches up whenever signaled, until the consumer is closed. A failed catch-up is
+// retried after a delay, the signals received in the meantime waiting for the retry.
+func (cs *Consumer) run(err error) {
+	defer close(cs.stopped)
+	for attempt := 0; ; {
+		var retry <-chan time.Time
+		if err != nil && cs.ctx.Err() == nil {
+			attempt++
+			if retry = cs.backoff(attempt, err); retry == nil {
+				attempt = 0 // Given up, until the next signal
+			}
+		} else {
+			attempt = 0
+		}
+
+		if !cs.wait(retry) {
+			return
+		}
+
+		err = cs.catchUp(cs.ctx)
+		if err != nil && !errors.Is(err, errNotProcessed) && cs.ctx.Err() == nil {
+			cs.client.logger.Warn("emitter: unable to catch up a consumer",
+				slog.String("channel", cs.channel),
+				slog.Any("error", err))
+		}
+	}
+}
+
+// wait waits for the retry if one is scheduled, or for a signal otherwise. It returns
+// false once the consumer is closed.
+func (cs *Consumer) wait(retry <-chan time.Time) bool {
+	wake := cs.wake
+	if retry != nil {
+		wake = nil // The signals wait for the retry
+	}
+
+	select {
+	case <-cs.ctx.Done():
+		return false
+	case <-retry:
+		return true
+	case <-wake:
+		return true
+	}
+}
+
+// backoff returns a channel which fires once a failed catch-up should be attempted
+// again, following the retry policy, or nil if it gives up.
+func (cs *Consumer) backoff(attempt int, err error) <-chan time.Time {
+	c := cs.client
+	policy := c.retryPolicy
+	if policy == nil {
+		policy = defaultConsumeRetry
+	}
+
+	delay, ok := policy.NextRetry("consume", attempt, err)
+	a := Attempt{Operation: "consume", Number: attempt, Delay: delay, Err: err}
+	if !ok {
+		c.notifyGiveUp(a)
+		return nil
+	}
+
+	if !c.notifyAttempt(a) {
+		return nil
+	}
+	return time.After(delay)
+}
+
+// catchUp processes the messages stored after the last one processed, recording the
+// cursor of each one. It stops at the first message the handler fails to process, and
+// returns errNotProcessed.
+func (cs *Consumer) catchUp(ctx context.Context) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	c := cs.client
+	q := c.HistoryQuery(cs.key, cs.channel).Order(OldestFirst)
+	if cs.cursor != "" {
+		q = q.After(cs.cursor)
+	}
+
+	for m, err := range q.Messages(ctx) {
+		if err == nil {
+			err = ctx.Err() // The consumer may be closed between two messages
+		}
+		if err != nil {
+			return err
+		}
+
+		if !cs.process(ctx, &storedMessage{m}) {
+			return errNotProcessed
+		}
+
+		// The cursor is not recorded once the consumer is closed
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		cursor := m.Cursor()
+		if err := cs.checkpoints.Save(ctx, cs.channel, cursor); err != nil {
+			return err
+		}
+		cs.cursor = cursor
+	}
+	return nil
+}
+
+// process calls the handler for a message, and returns whether it succeeded. The
+// failures are reported to the failure handler.
+func (cs *Consumer) process(ctx context.Context, m Message) (ok bool) {
+	c := cs.client
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	defer c.recoverHandler(m.Topic(), m.Payload())
+
+	cs.handling.Store(goroutineID())
+	defer cs.handling.Store(0)
+	if err := cs.handler(ctx, c, m); err != nil {
+		c.fail(&HandlerError{
+			Topic:   m.Topic(),
+			Payload: m.Payload(),
+			Err:     err,
+		})
+		return false
+	}
+	return true
+}
+
+// goroutineID returns the identifier of the calling goroutine, read from the header of
+// its stack trace, so that the consumer can recognize a call from its handler.
+func goroutineID() uint64 {
+	var buf [64]byte
+	header := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(header, ' '); i > 0 {
+		header = header[:i]
+	}
+
+	id, _ := strconv.ParseUint(string(header), 10, 64)
+	return id
+}
+
+// ------------------------------------------------------------------------------------
+
+// consumers keeps track of the durable consumers of a client, which catch up once the
+// client reconnects.
+type consumers struct {
+	*watchers[*Consumer]
+}
+
+// newConsumers creates a new consumer registry.
+func newConsumers() *consumers {
+	return &consumers{newWatchers[*Consumer]()}
+}
+
+// resumeConsumers signals every durable consumer to catch up, since the messages stored
+// while the client was disconnected were not received.
+func (c *Client) resumeConsumers() {
+	for _, cs := range c.consumers.All() {
+		cs.notify()
+	}
+}
@@ -0,0 +1,301 @@
+package emitter
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+// collector records the payloads processed by a consumer.
+type collector struct {
+	sync.Mutex
+	payloads []string
+}
+
+func (c *collector) handle(_ context.Context, _ *Client, m Message) error {
+	c.Lock()
+	defer c.Unlock()
+	c.payloads = append(c.payloads, string(m.Payload()))
+	return nil
+}
+
+func (c *collector) get() []string {
+	c.Lock()
+	defer c.Unlock()
+	return append([]string{}, c.payloads...)
+}
+
+// recorder is a checkpointer which records the cursors saved.
+type recorder struct {
+	sync.Mutex
+	saved []HistoryCursor
+}
+
+func (r *recorder) Load(context.Context, string) (HistoryCursor, error) {
+	return "", nil
+}
+
+func (r *recorder) Save(_ context.Context, _ string, cursor HistoryCursor) error {
+	r.Lock()
+	defer r.Unlock()
+	r.saved = append(r.saved, cursor)
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.saved)
+}
+
+func TestConsumer(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	assert.NoError(t, c.Publish("key", "jobs/", "1", WithTTL(60)))
+	assert.NoError(t, c.Publish("key", "jobs/", "2", WithTTL(60)))
+
+	// The messages stored are processed before the consumer is returned
+	var processed collector
+	cs, err := c.Consume(ctx, "key", "jobs", NewFileCheckpointer(path), processed.handle)
+	assert.NoError(t, err)
+	assert.Equal(t, "jobs/", cs.Channel())
+	assert.Equal(t, []string{"1", "2"}, processed.get())
+
+	// Then the new messages are processed as they arrive
+	assert.NoError(t, c.Publish("key", "jobs/", "3", WithTTL(60)))
+	assert.Eventually(t, func() bool { return len(processed.get()) == 3 }, time.Second, 10*time.Millisecond)
+	assert.NoError(t, cs.Close())
+	assert.NoError(t, cs.Close())
+
+	// Once restarted, the consumer resumes after the last message processed
+	assert.NoError(t, c.Publish("key", "jobs/", "4", WithTTL(60)))
+	var resumed collector
+	cs, err = c.Consume(ctx, "key", "jobs/", NewFileCheckpointer(path), resumed.handle)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"4"}, resumed.get())
+	assert.NoError(t, cs.Close())
+}
+
+func TestConsumerFailure(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil, WithRetryPolicy(&Backoff{Min: 10 * time.Millisecond}))
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	failures := make(chan string, 10)
+	c.OnHandlerFailure(func(_ *Client, e *HandlerError) { failures <- string(e.Payload) })
+	retries := make(chan Attempt, 10)
+	c.OnAttempt(func(_ *Client, a Attempt) bool {
+		retries <- a
+		return true
+	})
+
+	// The message is processed again after a delay, until the handler succeeds
+	var mu sync.Mutex
+	var attempts []string
+	fail := true
+	handler := func(_ context.Context, _ *Client, m Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, string(m.Payload()))
+		if fail && string(m.Payload()) == "2" {
+			fail = false
+			return errors.New("unable to process")
+		}
+		return nil
+	}
+
+	assert.NoError(t, c.Publish("key", "jobs/", "1", WithTTL(60)))
+	assert.NoError(t, c.Publish("key", "jobs/", "2", WithTTL(60)))
+	cs, err := c.Consume(context.Background(), "key", "jobs/", NewFileCheckpointer(filepath.Join(t.TempDir(), "c.json")), handler)
+	assert.NoError(t, err)
+	defer cs.Close()
+	assert.Equal(t, "2", receive(t, failures))
+
+	select {
+	case a := <-retries:
+		assert.Equal(t, "consume", a.Operation)
+		assert.Equal(t, 1, a.Number)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the retry")
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(attempts) == 3
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"1", "2", "2"}, attempts)
+}
+
+func TestConsumerBackoff(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil, WithRetryPolicy(&Backoff{Min: time.Hour}))
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	// A message which always fails is not processed again on every new message
+	var mu sync.Mutex
+	var attempts []string
+	handler := func(_ context.Context, _ *Client, m Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, string(m.Payload()))
+		return errors.New("unable to process")
+	}
+
+	assert.NoError(t, c.Publish("key", "jobs/", "1", WithTTL(60)))
+	cs, err := c.Consume(context.Background(), "key", "jobs/", NewFileCheckpointer(filepath.Join(t.TempDir(), "c.json")), handler)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, c.Publish("key", "jobs/", "2", WithTTL(60)))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []string{"1"}, attempts)
+	mu.Unlock()
+	assert.NoError(t, cs.Close())
+}
+
+func TestConsumerCloseFromHandler(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	closed := make(chan error, 1)
+	var cs *Consumer
+	cs, err = c.Consume(context.Background(), "key", "jobs/", NewFileCheckpointer(filepath.Join(t.TempDir(), "c.json")), func(context.Context, *Client, Message) error {
+		closed <- cs.Close()
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Publish("key", "jobs/", "1", WithTTL(60)))
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the consumer to close")
+	}
+
+	select {
+	case <-cs.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the consumer to stop")
+	}
+}
+
+func TestConsumerCloseFromFirstCatchUp(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, c.Publish("key", "jobs/", strconv.Itoa(i), WithTTL(60)))
+	}
+
+	// Closing the consumer during the first catch-up stops it right away
+	var processed collector
+	var checkpoints recorder
+	cs, err := c.Consume(context.Background(), "key", "jobs/", &checkpoints, func(ctx context.Context, c *Client, m Message) error {
+		assert.NoError(t, c.consumers.All()[0].Close())
+		return processed.handle(ctx, c, m)
+	})
+	assert.Nil(t, cs)
+	assert.ErrorIs(t, err, ErrConsumerClosed)
+	assert.Equal(t, []string{"1"}, processed.get())
+	assert.Equal(t, 0, checkpoints.count())
+	assert.Empty(t, c.consumers.All())
+}
+
+func TestConsumerCloseWaits(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var checkpoints recorder
+	cs, err := c.Consume(context.Background(), "key", "jobs/", &checkpoints, func(context.Context, *Client, Message) error {
+		close(started)
+		<-release
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, c.Publish("key", "jobs/", "1", WithTTL(60)))
+	<-started
+
+	// Closing from another goroutine waits for the handler to return
+	closed := make(chan error, 1)
+	go func() { closed <- cs.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("closed while the handler was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NoError(t, <-closed)
+	assert.Equal(t, 0, checkpoints.count())
+}
+
+func TestConsumerReconnect(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	connected := make(chan struct{}, 1)
+	c := NewClient(WithBrokers(srv.URL), WithAutoReconnect(true))
+	c.OnConnect(func(_ *Client) { connected <- struct{}{} })
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+	<-connected
+
+	var processed collector
+	cs, err := c.Consume(context.Background(), "key", "jobs/", NewFileCheckpointer(filepath.Join(t.TempDir(), "c.json")), processed.handle)
+	assert.NoError(t, err)
+	defer cs.Close()
+
+	// The message stored while disconnected is processed once reconnected
+	srv.CloseClientConnections()
+	other, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer other.Disconnect(0)
+	assert.NoError(t, other.Publish("key", "jobs/", "1", WithTTL(60)))
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the reconnection")
+	}
+
+	assert.Eventually(t, func() bool { return len(processed.get()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.NotEmpty(t, cs.Cursor())
+}
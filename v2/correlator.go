@@ -0,0 +1,143 @@
+package emitter
+
+import (
+	"fmt"
+	"sync"
+)
+
+// RequestStats represents the counters of the requests made to the broker.
+type RequestStats struct {
+	Outstanding int    // The number of requests awaiting a response
+	Completed   uint64 // The number of requests which received a response
+	Failed      uint64 // The number of requests which received an error response
+	TimedOut    uint64 // The number of requests which timed out
+	Canceled    uint64 // The number of requests abandoned by the caller
+	Orphaned    uint64 // The number of requests failed by a connection loss
+}
+
+// RequestError represents a request which failed without a response from the broker.
+type RequestError struct {
+	Operation string // The operation requested, such as "keygen" or "presence"
+	ID        uint16 // The identifier of the request
+	Err       error  // The reason of the failure
+}
+
+// Error returns the error message.
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("emitter: %s request #%d failed, due to %s", e.Operation, e.ID, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// ------------------------------------------------------------------------------------
+
+// call represents a request awaiting its response.
+type call struct {
+	op     string      // The operation requested
+	id     uint16      // The identifier of the request, which the response carries
+	result chan result // The channel which receives the outcome of the request
+}
+
+// result represents the outcome of a request.
+type result struct {
+	resp Response
+	err  error
+}
+
+// correlator matches the responses of the broker with the requests awaiting them. The
+// emitter broker replies with the MQTT packet ID of the request, which identifies the
+// request until it is completed.
+type correlator struct {
+	sync.Mutex
+	calls map[uint16]*call
+	stats RequestStats
+}
+
+// newCorrelator creates a new correlator.
+func newCorrelator() *correlator {
+	return &correlator{
+		calls: make(map[uint16]*call),
+	}
+}
+
+// Register registers a request awaiting its response. Since packet IDs are reused once
+// acknowledged, a request still registered with the same ID never received its response
+// and is failed.
+func (r *correlator) Register(op string, id uint16) *call {
+	r.Lock()
+	defer r.Unlock()
+
+	if prev, ok := r.calls[id]; ok {
+		r.stats.TimedOut++
+		prev.result <- result{err: &RequestError{Operation: prev.op, ID: id, Err: ErrTimeout}}
+	}
+
+	c := &call{op: op, id: id, result: make(chan result, 1)}
+	r.calls[id] = c
+	return c
+}
+
+// Notify completes the request with the response provided. It returns false if no
+// request with that ID is awaiting a response.
+func (r *correlator) Notify(id uint16, resp Response) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	c, ok := r.calls[id]
+	if !ok {
+		return false
+	}
+
+	delete(r.calls, id)
+	if err, isError := resp.(error); isError {
+		r.stats.Failed++
+		c.result <- result{err: err}
+		return true
+	}
+
+	r.stats.Completed++
+	c.result <- result{resp: resp}
+	return true
+}
+
+// Cancel abandons a request, which will ignore its response if it arrives later.
+func (r *correlator) Cancel(c *call, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.calls[c.id] != c {
+		return // Already completed
+	}
+
+	delete(r.calls, c.id)
+	if err == ErrTimeout {
+		r.stats.TimedOut++
+	} else {
+		r.stats.Canceled++
+	}
+}
+
+// FailAll fails every outstanding request with the error provided.
+func (r *correlator) FailAll(err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	for id, c := range r.calls {
+		r.stats.Orphaned++
+		c.result <- result{err: &RequestError{Operation: c.op, ID: id, Err: err}}
+		delete(r.calls, id)
+	}
+}
+
+// Stats returns the current counters.
+func (r *correlator) Stats() RequestStats {
+	r.Lock()
+	defer r.Unlock()
+
+	stats := r.stats
+	stats.Outstanding = len(r.calls)
+	return stats
+}
@@ -0,0 +1,72 @@
+package emitter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrelatorNotify(t *testing.T) {
+	r := newCorrelator()
+	c := r.Register("me", 1)
+	assert.Equal(t, 1, r.Stats().Outstanding)
+
+	assert.False(t, r.Notify(2, &meResponse{Request: 2}))
+	assert.True(t, r.Notify(1, &meResponse{Request: 1, ID: "abc"}))
+	res := <-c.result
+	assert.NoError(t, res.err)
+	assert.Equal(t, "abc", res.resp.(*meResponse).ID)
+
+	// Error responses complete the request with the error
+	c = r.Register("keygen", 2)
+	assert.True(t, r.Notify(2, &Error{Request: 2, Status: 401}))
+	res = <-c.result
+	assert.Equal(t, 401, res.err.(*Error).Status)
+
+	stats := r.Stats()
+	assert.Equal(t, 0, stats.Outstanding)
+	assert.Equal(t, uint64(1), stats.Completed)
+	assert.Equal(t, uint64(1), stats.Failed)
+}
+
+func TestCorrelatorCancel(t *testing.T) {
+	r := newCorrelator()
+	r.Cancel(r.Register("me", 1), ErrTimeout)
+	r.Cancel(r.Register("me", 2), errors.New("canceled"))
+	assert.False(t, r.Notify(1, &meResponse{Request: 1}))
+
+	stats := r.Stats()
+	assert.Equal(t, 0, stats.Outstanding)
+	assert.Equal(t, uint64(1), stats.TimedOut)
+	assert.Equal(t, uint64(1), stats.Canceled)
+}
+
+func TestCorrelatorFailAll(t *testing.T) {
+	r := newCorrelator()
+	c1 := r.Register("presence", 1)
+	c2 := r.Register("history", 2)
+	r.FailAll(ErrDisconnected)
+
+	for _, c := range []*call{c1, c2} {
+		res := <-c.result
+		var reqErr *RequestError
+		assert.True(t, errors.As(res.err, &reqErr))
+		assert.Equal(t, c.op, reqErr.Operation)
+		assert.ErrorIs(t, res.err, ErrDisconnected)
+	}
+
+	assert.Equal(t, uint64(2), r.Stats().Orphaned)
+	assert.Equal(t, 0, r.Stats().Outstanding)
+}
+
+func TestCorrelatorReusedID(t *testing.T) {
+	r := newCorrelator()
+	old := r.Register("me", 1)
+	current := r.Register("me", 1)
+
+	res := <-old.result
+	assert.ErrorIs(t, res.err, ErrTimeout)
+	assert.True(t, r.Notify(1, &meResponse{Request: 1}))
+	assert.NoError(t, (<-current.result).err)
+}
@@ -0,0 +1,147 @@
+package emitter
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// dispatcher runs the message handlers on a pool of workers, so that a slow handler does
+// not stall the other channels nor the responses of the broker. The messages which share
+// a key, by default their topic, are queued to the same worker and handled in order.
+type dispatcher struct {
+	sync.RWMutex
+	workers int                  // The number of workers
+	size    int                  // The capacity of the queue of each worker
+	policy  OverflowPolicy       // The policy to apply when a queue is full
+	key     func(Message) string // The function which returns the ordering key of a message
+	queues  []chan Message       // The queues of the workers, nil when stopped
+	stop    chan struct{}        // Closed to stop the workers
+	done    sync.WaitGroup       // The running workers
+}
+
+// keyFunc returns the ordering key of a message.
+type keyFunc func(Message) string
+
+// newDispatcher creates a new dispatcher, which must be started before use.
+func newDispatcher(workers, size int, policy OverflowPolicy) *dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	if size < 1 {
+		size = 1
+	}
+
+	return &dispatcher{
+		workers: workers,
+		size:    size,
+		policy:  policy,
+		key:     Message.Topic,
+	}
+}
+
+// Start starts the workers, which handle the messages with the function provided, unless
+// they are already running.
+func (d *dispatcher) Start(handle func(Message)) {
+	d.Lock()
+	defer d.Unlock()
+	if d.queues != nil {
+		return
+	}
+
+	d.queues = make([]chan Message, d.workers)
+	d.stop = make(chan struct{})
+	for i := range d.queues {
+		queue := make(chan Message, d.size)
+		d.queues[i] = queue
+		d.done.Add(1)
+		go d.work(queue, d.stop, handle)
+	}
+}
+
+// work handles the messages of a queue until the dispatcher is stopped, then the
+// messages left in the queue.
+func (d *dispatcher) work(queue chan Message, stop chan struct{}, handle func(Message)) {
+	defer d.done.Done()
+	for {
+		select {
+		case m := <-queue:
+			handle(m)
+		case <-stop:
+			for {
+				select {
+				case m := <-queue:
+					handle(m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Stop signals the workers to stop once they handled the queued messages. It does not
+// wait for them, so that a handler running on a worker may stop the dispatcher.
+func (d *dispatcher) Stop() {
+	d.Lock()
+	defer d.Unlock()
+	if d.queues != nil {
+		close(d.stop)
+		d.queues = nil
+	}
+}
+
+// Dispatch queues a message to the worker of its key. It returns false if the dispatcher
+// is not running, in which case the caller should handle the message itself. When the
+// queue is full, the message dropped because of the overflow policy is returned.
+func (d *dispatcher) Dispatch(m Message) (dropped Message, ok bool) {
+	d.RLock()
+	if d.queues == nil {
+		d.RUnlock()
+		return nil, false
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(d.key(m)))
+	queue, stop := d.queues[h.Sum32()%uint32(len(d.queues))], d.stop
+	if d.policy == Block {
+		// Wait for some room without holding the lock, unless the dispatcher is stopped
+		d.RUnlock()
+		select {
+		case queue <- m:
+			return nil, true
+		case <-stop:
+			return nil, false
+		}
+	}
+
+	defer d.RUnlock()
+	return d.enqueue(queue, m)
+}
+
+// enqueue queues a message without waiting, dropping a message if the queue is full.
+// The dispatcher must be read-locked.
+func (d *dispatcher) enqueue(queue chan Message, m Message) (dropped Message, ok bool) {
+	if d.policy == DropOldest {
+		for {
+			select {
+			case queue <- m:
+				return dropped, true
+			default:
+			}
+
+			// Make some room, unless a worker already did
+			select {
+			case dropped = <-queue:
+			default:
+			}
+		}
+	}
+
+	select {
+	case queue <- m:
+		return nil, true
+	default:
+		return m, true
+	}
+}
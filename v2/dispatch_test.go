@@ -0,0 +1,137 @@
+package emitter
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchOrdering(t *testing.T) {
+	d := newDispatcher(4, 100, Block)
+
+	var mu sync.Mutex
+	received := make(map[string][]string)
+	d.Start(func(m Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		received[m.Topic()] = append(received[m.Topic()], string(m.Payload()))
+	})
+
+	for i := 0; i < 50; i++ {
+		for _, topic := range []string{"a/", "b/", "c/"} {
+			_, ok := d.Dispatch(&message{topic: topic, payload: fmt.Sprint(i)})
+			assert.True(t, ok)
+		}
+	}
+
+	d.Stop()
+	d.done.Wait()
+	for _, topic := range []string{"a/", "b/", "c/"} {
+		assert.Len(t, received[topic], 50)
+		for i, payload := range received[topic] {
+			assert.Equal(t, fmt.Sprint(i), payload)
+		}
+	}
+
+	// Once stopped, the caller handles the messages
+	_, ok := d.Dispatch(&message{topic: "a/"})
+	assert.False(t, ok)
+}
+
+func TestDispatchOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		policy  OverflowPolicy
+		dropped string
+	}{
+		{policy: DropNewest, dropped: "3"},
+		{policy: DropOldest, dropped: "2"},
+	} {
+		d := newDispatcher(1, 1, tc.policy)
+		release := make(chan struct{})
+		d.Start(func(m Message) { <-release })
+
+		// The worker holds the first message, the queue holds the second one
+		d.Dispatch(&message{topic: "a/", payload: "1"})
+		time.Sleep(10 * time.Millisecond)
+		d.Dispatch(&message{topic: "a/", payload: "2"})
+
+		dropped, ok := d.Dispatch(&message{topic: "a/", payload: "3"})
+		assert.True(t, ok)
+		assert.Equal(t, tc.dropped, string(dropped.Payload()))
+
+		close(release)
+		d.Stop()
+	}
+}
+
+func TestDispatchParallel(t *testing.T) {
+	c := NewClient(WithDispatchKey(func(m Message) string {
+		return channelPrefix(m.Topic())
+	}), WithDispatcher(2, 10, Block))
+
+	slow, fast := make(chan struct{}), make(chan struct{})
+	c.handlers.AddHandler("slow/", func(*Client, Message) { <-slow })
+	c.handlers.AddHandler("fast/", func(*Client, Message) { close(fast) })
+	c.dispatcher.Start(c.route)
+
+	// A handler blocked on a channel does not stall the other channels
+	c.onMessage(nil, &message{topic: "slow/a/"})
+	c.onMessage(nil, &message{topic: "fast/b/"})
+	select {
+	case <-fast:
+	case <-time.After(time.Second):
+		assert.Fail(t, "the fast handler was not called")
+	}
+
+	close(slow)
+	c.dispatcher.Stop()
+}
+
+func TestDispatchStopBlocked(t *testing.T) {
+	d := newDispatcher(1, 1, Block)
+	release := make(chan struct{})
+	d.Start(func(m Message) { <-release })
+
+	// The worker holds the first message, the queue holds the second one
+	d.Dispatch(&message{topic: "a/", payload: "1"})
+	time.Sleep(10 * time.Millisecond)
+	d.Dispatch(&message{topic: "a/", payload: "2"})
+
+	// A blocked dispatch does not prevent stopping, and returns the message
+	result := make(chan bool)
+	go func() {
+		_, ok := d.Dispatch(&message{topic: "a/", payload: "3"})
+		result <- ok
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	d.Stop()
+	assert.False(t, <-result)
+	close(release)
+	d.done.Wait()
+}
+
+func TestDisconnectFromHandler(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	disconnected := make(chan struct{})
+	c, err := Connect(srv.URL, func(c *Client, _ Message) {
+		c.Disconnect(0)
+		close(disconnected)
+	}, WithDispatcher(2, 10, Block))
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Subscribe("master-key", "a/", nil))
+	assert.NoError(t, c.Publish("master-key", "a/", "bye"))
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the disconnection")
+	}
+	assert.Equal(t, Closed, c.State())
+}
@@ -1,10 +1,13 @@
 package emitter
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -14,8 +17,15 @@ import (
 
 // Various emitter errors
 var (
-	ErrTimeout   = errors.New("emitter: operation has timed out")
-	ErrUnmarshal = errors.New("emitter: unable to unmarshal the response")
+	ErrTimeout           = errors.New("emitter: operation has timed out")
+	ErrUnmarshal         = errors.New("emitter: unable to unmarshal the response")
+	ErrOutboxFull        = errors.New("emitter: the persistent store is full")
+	ErrDisconnected      = errors.New("emitter: the connection was lost before a response was received")
+	ErrInvalidChannel    = errors.New("emitter: the channel is not valid")
+	ErrInvalidPermission = errors.New("emitter: the permissions are not valid")
+	ErrInvalidCursor     = errors.New("emitter: the history cursor is not valid")
+	ErrConsumerClosed    = errors.New("emitter: the consumer was closed")
+	ErrQueued            = errors.New("emitter: the message is queued and will be sent again on the next connection")
 )
 
 // Message defines the externals that a message implementation must support
@@ -29,17 +39,39 @@ type Message interface {
 // Client represents an emitter client which holds the connection.
 type Client struct {
 	sync.RWMutex
-	guid       string              // Emiter's client ID
-	conn       mqtt.Client         // MQTT client
-	opts       *mqtt.ClientOptions // MQTT options
-	store      *store              // In-flight requests store
-	handlers   *trie               // The registry for handlers
-	timeout    time.Duration       // Default timeout
-	message    MessageHandler      // User-defined message handler
-	connect    ConnectHandler      // User-defined connect handler
-	disconnect DisconnectHandler   // User-defined disconnect handler
-	presence   PresenceHandler     // User-defined presence handler
-	errors     ErrorHandler        // User-defined error handler
+	guid        string              // Emiter's client ID
+	conn        mqtt.Client         // MQTT client
+	opts        *mqtt.ClientOptions // MQTT options
+	store       *store              // In-flight messages store
+	requests    *correlator         // The requests awaiting a response
+	state       machine             // The connection state
+	outbox      *outbox             // Persistent store of QoS1 publishes, if any
+	limits      *outboxLimits       // The limits of the persistent store, if any
+	handlers    *trie               // The registry for handlers
+	dispatcher  *dispatcher         // The pool of workers running the handlers, if any
+	dispatchKey keyFunc             // The ordering key of the dispatched messages, if any
+	chain       chain               // The inbound and outbound middleware
+	subs        *registry           // The registry for active subscriptions
+	watches     *presenceRegistry   // The registry for presence handlers and trackers
+	consumers   *consumers          // The registry for durable consumers
+	resub       bool                // Whether subscriptions are restored on reconnect
+	resubLast   bool                // Whether restored subscriptions request the history
+	timeout     time.Duration       // Default timeout
+	message     MessageHandler      // User-defined message handler
+	connect     ConnectHandler      // User-defined connect handler
+	disconnect  DisconnectHandler   // User-defined disconnect handler
+	presence    PresenceHandler     // User-defined presence handler
+	errors      ErrorHandler        // User-defined error handler
+	restore     RestoreHandler      // User-defined restore failure handler
+	attempt     AttemptHandler      // User-defined reconnect and retry attempt handler
+	giveUp      GiveUpHandler       // User-defined give up handler
+	failure     FailureHandler      // User-defined handler failure handler
+	deadLetter  *deadLetter         // The channel receiving the messages which failed, if any
+	logger      *slog.Logger        // The logger for the diagnostics
+	metrics     Metrics             // The sink of the measurements
+	reconnect   ReconnectPolicy     // The reconnect policy, if any
+	retryPolicy RetryPolicy         // The retry policy, if any
+	keys        KeyProvider         // The provider of the keys, if any
 }
 
 // Connect is a convenience function which sets a broker and connects to it.
@@ -63,15 +95,23 @@ func Connect(host string, handler MessageHandler, options ...func(*Client)) (*Cl
 // connection) are created before the application is actually ready.
 func NewClient(options ...func(*Client)) *Client {
 	c := &Client{
-		opts:     mqtt.NewClientOptions(),
-		timeout:  60 * time.Second,
-		store:    new(store),
-		handlers: NewTrie(),
+		opts:      mqtt.NewClientOptions(),
+		timeout:   60 * time.Second,
+		store:     newStore(),
+		requests:  newCorrelator(),
+		handlers:  NewTrie(),
+		subs:      newRegistry(),
+		watches:   newPresenceRegistry(),
+		consumers: newConsumers(),
+		resub:     true,
+		resubLast: true,
 	}
 
 	// Set handlers
 	c.opts.SetOnConnectHandler(c.onConnect)
 	c.opts.SetConnectionLostHandler(c.onConnectionLost)
+	c.opts.SetReconnectingHandler(c.onReconnecting)
+	c.opts.SetConnectionAttemptHandler(c.onConnectionAttempt)
 	c.opts.SetDefaultPublishHandler(c.onMessage)
 	c.opts.SetClientID(uuid())
 	c.opts.SetStore(c.store)
@@ -84,6 +124,30 @@ func NewClient(options ...func(*Client)) *Client {
 		opt(c)
 	}
 
+	// The options which configure the outbox and the dispatcher may come in any order
+	if c.outbox != nil && c.limits != nil {
+		c.outbox.maxBytes = c.limits.maxBytes
+		c.outbox.maxAge = c.limits.maxAge
+		c.outbox.policy = c.limits.policy
+	}
+
+	if c.dispatcher != nil && c.dispatchKey != nil {
+		c.dispatcher.key = c.dispatchKey
+	}
+
+	if c.logger == nil {
+		c.logger = slog.Default()
+	}
+
+	if c.metrics == nil {
+		c.metrics = nopMetrics{}
+	}
+
+	// The reconnect policy replaces the automatic reconnection of the MQTT client
+	if c.reconnect != nil {
+		c.opts.SetAutoReconnect(false)
+	}
+
 	// Create the underlying MQTT client and set the options
 	c.conn = mqtt.NewClient(c.opts)
 	return c
@@ -107,27 +171,154 @@ func (c *Client) OnDisconnect(handler DisconnectHandler) {
 	c.disconnect = handler
 }
 
-// OnPresence sets the function that will be called when a presence event is received.
+// OnPresence sets the function that will be called when a presence event is received
+// and no handler subscribed with SubscribePresence matches its channel.
 func (c *Client) OnPresence(handler PresenceHandler) {
 	c.presence = handler
 }
 
+// OnRestoreFailure sets the function that will be called when some of the subscriptions
+// could not be restored after the client reconnected.
+func (c *Client) OnRestoreFailure(handler RestoreHandler) {
+	c.restore = handler
+}
+
 // onConnect occurs when MQTT client is connected
 func (c *Client) onConnect(_ mqtt.Client) {
+	if c.State() == Reconnecting {
+		c.metrics.Add(MetricReconnects, 1)
+	}
+
+	c.setState(Connected, nil)
+	if c.resub {
+		c.resubscribe()
+	}
+
+	c.resyncPresence()
+	c.resumeConsumers()
+
+	if c.outbox != nil {
+		c.flushOutbox()
+	}
+
 	if c.connect != nil {
 		c.connect(c)
 	}
 }
 
+// resubscribe re-issues every active subscription, since they are lost when the
+// connection is re-established with a clean session.
+func (c *Client) resubscribe() {
+	var failures []RestoreError
+	for _, sub := range c.subs.All() {
+		if err := c.restoreSubscription(sub); err != nil {
+			failures = append(failures, RestoreError{
+				Key:     sub.Key,
+				Channel: sub.Channel,
+				Group:   sub.Group,
+				Err:     err,
+			})
+		}
+	}
+
+	switch {
+	case len(failures) == 0:
+	case c.restore != nil:
+		c.restore(c, failures)
+	default:
+		for _, f := range failures {
+			c.logger.Warn("emitter: unable to restore a subscription",
+				slog.String("channel", f.Channel),
+				slog.String("group", f.Group),
+				slog.Any("error", f.Err))
+		}
+	}
+}
+
+// restoreSubscription re-issues a single subscription.
+func (c *Client) restoreSubscription(sub *subscription) error {
+	ctx, cancel := c.withTimeout(context.Background())
+	defer cancel()
+
+	if sub.Link != nil {
+		link := *sub.Link
+		channel, query, _ := strings.Cut(link.Channel, "?")
+		var options []Option
+		for _, o := range strings.Split(query, "&") {
+			if o != "" {
+				options = append(options, option(o))
+			}
+		}
+
+		key, err := c.resolveKey(ctx, link.Key, channel, permissionsFor(linkPermissions, options))
+		if err != nil {
+			return err
+		}
+
+		link.Key = key
+		_, err = c.request(ctx, "link", &link)
+		return err
+	}
+
+	topic, err := c.subscriptionTopic(ctx, sub, c.resubLast)
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, c.conn.Subscribe(topic, 0, nil))
+}
+
+// subscriptionTopic returns the topic of a subscription, with the key of the key
+// provider if the subscription was made with an empty key.
+func (c *Client) subscriptionTopic(ctx context.Context, sub *subscription, withHistory bool) (string, error) {
+	options := sub.Options
+	if !withHistory {
+		options = withoutHistory(options)
+	}
+
+	key, err := c.resolveKey(ctx, sub.Key, sub.Channel, permissionsFor(PermRead, options))
+	if err != nil {
+		return "", err
+	}
+
+	resolved := *sub
+	resolved.Key = key
+	return resolved.topic(withHistory), nil
+}
+
 // onConnectionLost occurs when MQTT client is disconnected
 func (c *Client) onConnectionLost(_ mqtt.Client, e error) {
+	switch {
+	case c.reconnect != nil:
+		c.setState(Reconnecting, e)
+		go c.reconnectLoop(e)
+	case c.opts.AutoReconnect:
+		c.setState(Reconnecting, e)
+	default:
+		c.setState(Disconnected, e)
+	}
+
+	c.logger.Warn("emitter: connection lost",
+		slog.String("broker", c.Status().Broker),
+		slog.Any("error", e))
+
+	c.requests.FailAll(ErrDisconnected)
 	if c.disconnect != nil {
 		c.disconnect(c, e)
-	} else {
-		log.Println("emitter: connection lost, due to", e.Error())
 	}
 }
 
+// onReconnecting occurs when MQTT client attempts to reconnect
+func (c *Client) onReconnecting(_ mqtt.Client, _ *mqtt.ClientOptions) {
+	c.setState(Reconnecting, nil)
+}
+
+// onConnectionAttempt occurs when MQTT client attempts to connect to a broker
+func (c *Client) onConnectionAttempt(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+	c.state.setBroker(broker.String())
+	return tlsCfg
+}
+
 // OnError will set the function callback to be executed if an emitter-specific
 // error occurs.
 func (c *Client) OnError(handler ErrorHandler) {
@@ -137,21 +328,35 @@ func (c *Client) OnError(handler ErrorHandler) {
 
 // onMessage occurs when MQTT client receives a message
 func (c *Client) onMessage(_ mqtt.Client, m mqtt.Message) {
+	c.metrics.Add(MetricBytesReceived, float64(len(m.Payload())))
 	if !strings.HasPrefix(m.Topic(), "emitter/") {
-		handlers := c.handlers.Lookup(m.Topic())
-		if len(handlers) == 0 && c.message != nil { // Invoke the default message handler
-			c.message(c, m)
+		c.metrics.Add(MetricMessagesReceived, 1, Label{"prefix", channelPrefix(m.Topic())})
+		if c.dispatcher == nil {
+			c.route(m)
+			return
 		}
 
-		// Call each handler
-		for _, h := range handlers {
-			h(c, m)
+		// Hand the message over to the workers, or handle it here if they are stopped
+		dropped, ok := c.dispatcher.Dispatch(m)
+		switch {
+		case !ok:
+			c.route(m)
+		case dropped != nil:
+			c.metrics.Add(MetricMessagesDropped, 1, Label{"prefix", channelPrefix(dropped.Topic())})
+			c.logger.Warn("emitter: message dropped, the dispatch queue is full",
+				slog.String("topic", dropped.Topic()))
 		}
 		return
 	}
 
-	// `onError` and `onResponse` read the callbacks store when calling
-	// the `NotifyResponse`. See the comments in the `request` function.
+	// The presence change events are handled without locking the client
+	if strings.HasPrefix(m.Topic(), "emitter/presence/") {
+		c.onPresence(m)
+		return
+	}
+
+	// `onError` and `onResponse` complete the pending requests when calling
+	// the `Notify`. See the comments in the `request` function.
 	c.RLock()
 	defer c.RUnlock()
 
@@ -161,40 +366,6 @@ func (c *Client) onMessage(_ mqtt.Client, m mqtt.Message) {
 	case strings.HasPrefix(m.Topic(), "emitter/error/"):
 		c.onError(m)
 
-	// Dispatch presence handler
-	case strings.HasPrefix(m.Topic(), "emitter/presence/"):
-		var presenceResp presenceResponse
-		if err := json.Unmarshal(m.Payload(), &presenceResp); err != nil {
-			log.Println("emitter:", err.Error())
-			return
-		}
-
-		// If it's not the "status" response of the Presence RPC but a "change" event, we call the handler
-		// and stop there. We locked the client to for nothing in this case. There is no other choice.
-		r := PresenceEvent{presenceResp, make([]PresenceInfo, 0)}
-		if c.presence != nil && presenceResp.Event != "" && presenceResp.Event != "status" { // If we didn't request a status the Event will be empty.
-			r.Who = append(r.Who, PresenceInfo{})
-			if err := json.Unmarshal([]byte(presenceResp.Who), &r.Who[0]); err != nil {
-				log.Println("emitter:", err.Error())
-				return
-			}
-			c.presence(c, r)
-		} else if presenceResp.RequestID() > 0 {
-			// In this case, we have a "status" response of the Presence RPC. And this could be an error.
-			// Check if we've got an error response
-			var errResponse Error
-			if err := json.Unmarshal(m.Payload(), &errResponse); err == nil && errResponse.Error() != "" {
-				c.store.NotifyResponse(errResponse.RequestID(), &errResponse)
-				return
-			}
-
-			if err := json.Unmarshal([]byte(presenceResp.Who), &r.Who); err != nil {
-				log.Println("emitter:", err.Error())
-				return
-			}
-			c.store.NotifyResponse(presenceResp.RequestID(), &r)
-		}
-
 	case strings.HasPrefix(m.Topic(), "emitter/keygen/"):
 		c.onResponse(m, new(keyGenResponse))
 
@@ -215,7 +386,79 @@ func (c *Client) onMessage(_ mqtt.Client, m mqtt.Message) {
 		c.onResponse(m, new(historyResponse))
 
 	default:
+		c.logUnroutable(m, 0)
+	}
+}
 
+// onPresence handles a presence message, which is either a change event or the status
+// response of a presence request.
+func (c *Client) onPresence(m mqtt.Message) {
+	var presenceResp presenceResponse
+	if err := json.Unmarshal(m.Payload(), &presenceResp); err != nil {
+		c.logUnmarshal(m, err)
+		return
+	}
+
+	// If it's not the "status" response of the Presence RPC but a "change" event, we route it to
+	// the trackers and the handlers and stop there. The client is not locked, so that the
+	// handlers may make requests.
+	if presenceResp.Event != "" && presenceResp.Event != "status" { // If we didn't request a status the Event will be empty.
+		r, err := presenceResp.event()
+		if err != nil {
+			c.logUnmarshal(m, err)
+			return
+		}
+		c.routePresence(r, m)
+		return
+	}
+
+	if presenceResp.RequestID() == 0 {
+		return
+	}
+
+	// In this case, we have a "status" response of the Presence RPC. And this could be an error.
+	// The client is locked, see the comments in the `request` function.
+	c.RLock()
+	defer c.RUnlock()
+
+	// Check if we've got an error response
+	var errResponse Error
+	if err := json.Unmarshal(m.Payload(), &errResponse); err == nil && errResponse.Error() != "" {
+		c.notify(m, &errResponse)
+		return
+	}
+
+	r, err := presenceResp.event()
+	if err != nil {
+		c.logUnmarshal(m, err)
+		return
+	}
+
+	c.notify(m, &r)
+}
+
+// route calls the handlers matching the topic of a message, or the default message
+// handler if none matches.
+func (c *Client) route(m Message) {
+	start := time.Now()
+	defer func() {
+		c.metrics.Observe(MetricHandlerDuration, since(start), Label{"prefix", channelPrefix(m.Topic())})
+	}()
+
+	handlers := c.handlers.Lookup(m.Topic())
+	if len(handlers) == 0 {
+		if c.message == nil {
+			c.logger.Debug("emitter: message dropped, no handler matches its topic",
+				slog.String("topic", m.Topic()))
+			return
+		}
+
+		c.invoke(c.message, m) // Invoke the default message handler
+	}
+
+	// Call each handler
+	for _, h := range handlers {
+		c.invoke(h, m)
 	}
 }
 
@@ -225,30 +468,69 @@ func (c *Client) onResponse(m mqtt.Message, resp Response) bool {
 	// Check if we've got an error response
 	var errResponse Error
 	if err := json.Unmarshal(m.Payload(), &errResponse); err == nil && errResponse.Error() != "" {
-		return c.store.NotifyResponse(errResponse.RequestID(), &errResponse)
+		return c.notify(m, &errResponse)
 	}
 
 	// If it's not an error, try to unmarshal the response
-	if err := json.Unmarshal(m.Payload(), &resp); err == nil && resp.RequestID() > 0 {
-		return c.store.NotifyResponse(resp.RequestID(), resp)
+	err := json.Unmarshal(m.Payload(), &resp)
+	switch {
+	case err != nil:
+		c.logUnmarshal(m, err)
+	case resp.RequestID() > 0:
+		return c.notify(m, resp)
+	default:
+		c.logUnroutable(m, 0)
+	}
+	return false
+}
+
+// notify completes the request awaiting a response, or logs the response if no
+// request awaits it.
+func (c *Client) notify(m mqtt.Message, resp Response) bool {
+	if c.requests.Notify(resp.RequestID(), resp) {
+		return true
 	}
+
+	c.logUnroutable(m, resp.RequestID())
 	return false
 }
 
+// logUnmarshal logs a message which could not be decoded.
+func (c *Client) logUnmarshal(m mqtt.Message, err error) {
+	c.logger.Error("emitter: unable to unmarshal a message",
+		slog.String("topic", m.Topic()),
+		slog.Any("error", err))
+}
+
+// logUnroutable logs a response which no request awaits, for example because the
+// request was abandoned by the caller.
+func (c *Client) logUnroutable(m mqtt.Message, id uint16) {
+	c.logger.Warn("emitter: response does not match any pending request",
+		slog.String("topic", m.Topic()),
+		slog.Int("req", int(id)))
+}
+
 // OnError handles the incoming error.
 func (c *Client) onError(m mqtt.Message) {
 	var resp Error
 	if err := json.Unmarshal(m.Payload(), &resp); err != nil {
+		c.logUnmarshal(m, err)
 		return
 	}
 
-	if c.errors == nil {
-		log.Println("emitter:", resp.Error())
+	// An error replied to a request is returned to the caller
+	if resp.RequestID() > 0 && c.requests.Notify(resp.RequestID(), &resp) {
+		return
 	}
 
-	if c.errors != nil && !c.store.NotifyResponse(resp.RequestID(), &resp) {
-		c.errors(c, resp)
+	if c.errors == nil {
+		c.logger.Error("emitter: "+resp.Error(),
+			slog.Int("status", resp.Status),
+			slog.Int("req", int(resp.Request)))
+		return
 	}
+
+	c.errors(c, resp)
 }
 
 // IsConnected returns a bool signifying whether the client is connected or not.
@@ -256,25 +538,86 @@ func (c *Client) IsConnected() bool {
 	return c.conn.IsConnected()
 }
 
+// flushOutbox sends the publishes of the persistent store which were not yet
+// acknowledged by the broker, oldest first.
+func (c *Client) flushOutbox() {
+	pending, err := c.outbox.Acquire()
+	if err != nil {
+		c.logger.Error("emitter: unable to read the persistent store", slog.Any("error", err))
+		return
+	}
+
+	for i, e := range pending {
+		ctx, cancel := c.withTimeout(context.Background())
+		err := c.do(ctx, c.conn.Publish(e.Topic, 1, e.Retain, e.Payload))
+		cancel()
+		if err != nil {
+			for _, rest := range pending[i:] {
+				c.outbox.Release(rest.ID)
+			}
+			return
+		}
+
+		c.outbox.Ack(e.ID)
+	}
+}
+
 // Connect initiates a connection to the broker.
 func (c *Client) Connect() error {
-	return c.do(c.conn.Connect())
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext initiates a connection to the broker and waits until it is
+// established or the context is done.
+func (c *Client) ConnectContext(ctx context.Context) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if c.dispatcher != nil {
+		c.dispatcher.Start(c.route)
+	}
+
+	c.setState(Connecting, nil)
+	if err := c.do(ctx, c.conn.Connect()); err != nil {
+		c.setState(Disconnected, err)
+		return err
+	}
+
+	c.setState(Connected, nil)
+	return nil
 }
 
 // ID retrieves information about the client.
 func (c *Client) ID() string {
-	if c.guid != "" {
-		return c.guid
+	id, _ := c.IDContext(context.Background())
+	return id
+}
+
+// IDContext retrieves information about the client, honouring the deadline and
+// cancellation of the context.
+func (c *Client) IDContext(ctx context.Context) (string, error) {
+	c.RLock()
+	guid := c.guid
+	c.RUnlock()
+	if guid != "" {
+		return guid, nil
 	}
 
 	// Query the remote GUID, cast the response and store it
-	if resp, err := c.request("me", nil); err == nil {
-		if result, ok := resp.(*meResponse); ok {
-			c.guid = result.ID
-		}
+	resp, err := c.request(ctx, "me", nil)
+	if err != nil {
+		return "", err
+	}
+
+	result, ok := resp.(*meResponse)
+	if !ok {
+		return "", ErrUnmarshal
 	}
 
-	return c.guid
+	c.Lock()
+	c.guid = result.ID
+	c.Unlock()
+	return result.ID, nil
 }
 
 // Disconnect will end the connection with the server, but not before waiting
@@ -282,14 +625,100 @@ func (c *Client) ID() string {
 // completed.
 func (c *Client) Disconnect(waitTime time.Duration) {
 	c.conn.Disconnect(uint(waitTime.Nanoseconds() / 1000000))
+	c.setState(Closed, nil)
+	if c.outbox != nil {
+		c.outbox.Close()
+	}
+
+	if c.dispatcher != nil {
+		c.dispatcher.Stop()
+	}
 }
 
 // Publish will publish a message with the specified QoS and content to the specified topic.
 // Returns a token to track delivery of the message to the broker
 func (c *Client) Publish(key string, channel string, payload interface{}, options ...Option) error {
-	qos, retain := getHeader(options)
-	token := c.conn.Publish(formatTopic(key, channel, options), qos, retain, payload)
-	return c.do(token)
+	return c.PublishContext(context.Background(), key, channel, payload, options...)
+}
+
+// PublishContext publishes a message and waits for its delivery to the broker until
+// the context is done.
+func (c *Client) PublishContext(ctx context.Context, key string, channel string, payload interface{}, options ...Option) error {
+	return c.wrapPublish(c.send)(ctx, &Publication{
+		Key:     key,
+		Channel: channel,
+		Payload: payload,
+		Options: options,
+	})
+}
+
+// send publishes a message once it went through the outbound middleware.
+func (c *Client) send(ctx context.Context, p *Publication) error {
+	qos, retain := getHeader(p.Options)
+	topic := p.Channel
+	if !p.Link {
+		key, err := c.resolveKey(ctx, p.Key, p.Channel, permissionsFor(PermWrite, p.Options))
+		if err != nil {
+			return err
+		}
+
+		ch := NewChannel(p.Channel).WithKey(key).WithOptions(p.Options...)
+		if err := ch.Validate(); err != nil {
+			return err
+		}
+
+		if ch.IsWildcard() {
+			return fmt.Errorf("%w, wildcards can only be subscribed to", ErrInvalidChannel)
+		}
+		topic = ch.String()
+	}
+
+	var err error
+	if c.outbox != nil && qos == 1 && !p.Link {
+		ctx, cancel := c.withTimeout(ctx)
+		defer cancel()
+		err = c.publishDurable(ctx, topic, retain, p.Payload)
+	} else {
+		err = c.retry(ctx, "publish", func() error {
+			return c.publish(ctx, topic, qos, retain, p.Payload)
+		})
+	}
+
+	c.measurePublish(p.Channel, p.Payload, err)
+	return err
+}
+
+// publish publishes a message once and waits for its delivery to the broker.
+func (c *Client) publish(ctx context.Context, topic string, qos byte, retain bool, payload interface{}) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	token := c.conn.Publish(topic, qos, retain, payload)
+	return c.do(ctx, token)
+}
+
+// publishDurable persists a QoS1 publish before sending it, so it can be sent again
+// on the next connection if it is not acknowledged. While disconnected, the publish
+// is only queued. If it fails to be sent, it is kept and the error wraps ErrQueued.
+func (c *Client) publishDurable(ctx context.Context, topic string, retain bool, payload interface{}) error {
+	b, err := toBytes(payload)
+	if err != nil {
+		return err
+	}
+
+	online := c.conn.IsConnectionOpen()
+	id, err := c.outbox.Enqueue(topic, b, retain, online)
+	if err != nil || !online {
+		return err
+	}
+
+	// The message remains in the outbox, so retrying the publish would duplicate it
+	if err := c.do(ctx, c.conn.Publish(topic, 1, retain, b)); err != nil {
+		c.outbox.Release(id)
+		return fmt.Errorf("%w, %w", ErrQueued, err)
+	}
+
+	return c.outbox.Ack(id)
 }
 
 // PublishWithTTL publishes a message with a specified Time-To-Live option
@@ -305,35 +734,81 @@ func (c *Client) PublishWithRetain(key string, channel string, payload interface
 
 // PublishWithLink publishes a message with a specified link name instead of a channel key.
 func (c *Client) PublishWithLink(name string, payload interface{}, options ...Option) error {
-	qos, retain := getHeader(options)
-	token := c.conn.Publish(name, qos, retain, payload)
-	return c.do(token)
+	return c.PublishWithLinkContext(context.Background(), name, payload, options...)
+}
+
+// PublishWithLinkContext publishes a message using a link name and waits for its delivery
+// to the broker until the context is done.
+func (c *Client) PublishWithLinkContext(ctx context.Context, name string, payload interface{}, options ...Option) error {
+	return c.wrapPublish(c.send)(ctx, &Publication{
+		Channel: name,
+		Link:    true,
+		Payload: payload,
+		Options: options,
+	})
 }
 
 // Subscribe starts a new subscription. Provide a MessageHandler to be executed when
-// a message is published on the topic provided.
+// a message is published on the topic provided. Subscribing again to the channel
+// replaces the handler, see SubscribeHandle to add several handlers to a channel.
+// Without a handler, the messages go to the default message handler.
 func (c *Client) Subscribe(key string, channel string, optionalHandler MessageHandler, options ...Option) error {
-	if optionalHandler != nil {
-		c.handlers.AddHandler(channel, optionalHandler)
-	}
+	return c.SubscribeContext(context.Background(), key, channel, optionalHandler, options...)
+}
 
-	// https://github.com/eclipse/paho.mqtt.golang/blob/master/topic.go#L78
-	topic := strings.ReplaceAll(formatTopic(key, channel, options), "#/", "#")
+// SubscribeContext starts a new subscription and waits for the broker to acknowledge
+// it until the context is done.
+func (c *Client) SubscribeContext(ctx context.Context, key string, channel string, optionalHandler MessageHandler, options ...Option) error {
+	_, err := c.subscribe(ctx, &subscription{
+		Key:     key,
+		Channel: channel,
+		Options: options,
+		Handler: optionalHandler,
+	}, optionalHandler, true)
+	return err
+}
 
-	// Issue subscribe
-	token := c.conn.Subscribe(topic, 0, nil)
-	return c.do(token)
+// SubscribeHandle starts a new subscription and returns its handle, which unsubscribes
+// the handler provided without affecting the other handlers of the channel. Without a
+// handler, the messages which no other handler matches go to the default message
+// handler, and the handle does not count them.
+func (c *Client) SubscribeHandle(ctx context.Context, key string, channel string, optionalHandler MessageHandler, options ...Option) (*Subscription, error) {
+	return c.subscribe(ctx, &subscription{
+		Key:     key,
+		Channel: channel,
+		Options: options,
+		Handler: optionalHandler,
+	}, optionalHandler, false)
 }
 
 // SubscribeWithGroup creates a shared subscription to a share group.
 func (c *Client) SubscribeWithGroup(key, channel, shareGroup string, optionalHandler MessageHandler, options ...Option) error {
-	if optionalHandler != nil {
-		c.handlers.AddHandler(channel, optionalHandler)
-	}
+	return c.SubscribeWithGroupContext(context.Background(), key, channel, shareGroup, optionalHandler, options...)
+}
 
-	// Issue subscribe
-	token := c.conn.Subscribe(formatShare(key, shareGroup, channel, options), 0, nil)
-	return c.do(token)
+// SubscribeWithGroupContext creates a shared subscription to a share group and waits for
+// the broker to acknowledge it until the context is done.
+func (c *Client) SubscribeWithGroupContext(ctx context.Context, key, channel, shareGroup string, optionalHandler MessageHandler, options ...Option) error {
+	_, err := c.subscribe(ctx, &subscription{
+		Key:     key,
+		Channel: channel,
+		Group:   shareGroup,
+		Options: options,
+		Handler: optionalHandler,
+	}, optionalHandler, true)
+	return err
+}
+
+// SubscribeWithGroupHandle creates a shared subscription to a share group and returns
+// its handle.
+func (c *Client) SubscribeWithGroupHandle(ctx context.Context, key, channel, shareGroup string, optionalHandler MessageHandler, options ...Option) (*Subscription, error) {
+	return c.subscribe(ctx, &subscription{
+		Key:     key,
+		Channel: channel,
+		Group:   shareGroup,
+		Options: options,
+		Handler: optionalHandler,
+	}, optionalHandler, false)
 }
 
 // SubscribeWithHistory performs a subscribe with an option to retrieve the specified number
@@ -344,20 +819,56 @@ func (c *Client) SubscribeWithHistory(key string, channel string, last int, opti
 
 // Unsubscribe will end the subscription from each of the topics provided.
 // Messages published to those topics from other clients will no longer be
-// received.
+// received. Every handler of the channel is removed, along with the links which
+// subscribed to it, see Subscription to remove a single one.
 func (c *Client) Unsubscribe(key string, channel string) error {
+	return c.UnsubscribeContext(context.Background(), key, channel)
+}
+
+// UnsubscribeContext ends the subscription and waits for the broker to acknowledge it
+// until the context is done.
+func (c *Client) UnsubscribeContext(ctx context.Context, key string, channel string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 
 	// Remove the handler if we have one
 	c.handlers.RemoveHandler(channel)
+	c.subs.Remove(&subscription{Key: key, Channel: channel})
+	c.subs.RemoveLinks(channel)
+	c.metrics.Set(MetricSubscriptions, float64(c.subs.Len()))
 
 	// Issue the unsubscribe
+	key, err := c.resolveKey(ctx, key, channel, PermRead)
+	if err != nil {
+		return err
+	}
+
 	token := c.conn.Unsubscribe(formatTopic(key, channel, nil))
-	return c.do(token)
+	err = c.do(ctx, token)
+	c.measure("unsubscribe", err)
+	return err
+}
+
+// addSubscription keeps track of an active subscription.
+func (c *Client) addSubscription(sub *subscription) {
+	c.subs.Add(sub)
+	c.metrics.Set(MetricSubscriptions, float64(c.subs.Len()))
 }
 
 // Presence sends a presence request to the broker.
 func (c *Client) Presence(key, channel string, status, changes bool) (*PresenceEvent, error) {
-	resp, err := c.request("presence", &presenceRequest{
+	return c.PresenceContext(context.Background(), key, channel, status, changes)
+}
+
+// PresenceContext sends a presence request to the broker and waits for the response
+// until the context is done.
+func (c *Client) PresenceContext(ctx context.Context, key, channel string, status, changes bool) (*PresenceEvent, error) {
+	key, err := c.resolveKey(ctx, key, channel, PermPresence)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.request(ctx, "presence", &presenceRequest{
 		Key:     key,
 		Channel: channel,
 		Status:  status,
@@ -372,12 +883,17 @@ func (c *Client) Presence(key, channel string, status, changes bool) (*PresenceE
 		return result, nil
 	}
 	return nil, ErrUnmarshal
-	//return c.do(c.conn.Publish("emitter/presence/", 1, false, req))
 }
 
 // GenerateKey sends a key generation request to the broker
 func (c *Client) GenerateKey(key, channel, permissions string, ttl int) (string, string, error) {
-	resp, err := c.request("keygen", &keygenRequest{
+	return c.GenerateKeyContext(context.Background(), key, channel, permissions, ttl)
+}
+
+// GenerateKeyContext sends a key generation request to the broker and waits for the
+// response until the context is done.
+func (c *Client) GenerateKeyContext(ctx context.Context, key, channel, permissions string, ttl int) (string, string, error) {
+	resp, err := c.request(ctx, "keygen", &keygenRequest{
 		Key:     key,
 		Channel: channel,
 		Type:    permissions,
@@ -396,7 +912,13 @@ func (c *Client) GenerateKey(key, channel, permissions string, ttl int) (string,
 
 // BlockKey sends a request to block a key.
 func (c *Client) BlockKey(secretKey, targetKey string) (bool, error) {
-	resp, err := c.request("keyban", &keybanRequest{
+	return c.BlockKeyContext(context.Background(), secretKey, targetKey)
+}
+
+// BlockKeyContext sends a request to block a key and waits for the response until
+// the context is done.
+func (c *Client) BlockKeyContext(ctx context.Context, secretKey, targetKey string) (bool, error) {
+	resp, err := c.request(ctx, "keyban", &keybanRequest{
 		Secret: secretKey,
 		Target: targetKey,
 		Banned: true,
@@ -414,7 +936,13 @@ func (c *Client) BlockKey(secretKey, targetKey string) (bool, error) {
 
 // AllowKey sends a request to allow a previously blocked key.
 func (c *Client) AllowKey(secretKey, targetKey string) (bool, error) {
-	resp, err := c.request("keyban", &keybanRequest{
+	return c.AllowKeyContext(context.Background(), secretKey, targetKey)
+}
+
+// AllowKeyContext sends a request to allow a previously blocked key and waits for the
+// response until the context is done.
+func (c *Client) AllowKeyContext(ctx context.Context, secretKey, targetKey string) (bool, error) {
+	resp, err := c.request(ctx, "keyban", &keybanRequest{
 		Secret: secretKey,
 		Target: targetKey,
 		Banned: false,
@@ -432,13 +960,26 @@ func (c *Client) AllowKey(secretKey, targetKey string) (bool, error) {
 
 // CreateLink sends a request to create a default link.
 func (c *Client) CreateLink(key, channel, name string, optionalHandler MessageHandler, options ...Option) (*Link, error) {
-	resp, err := c.request("link", &linkRequest{
+	return c.CreateLinkContext(context.Background(), key, channel, name, optionalHandler, options...)
+}
+
+// CreateLinkContext sends a request to create a default link and waits for the response
+// until the context is done.
+func (c *Client) CreateLinkContext(ctx context.Context, key, channel, name string, optionalHandler MessageHandler, options ...Option) (link *Link, err error) {
+	req := &linkRequest{
 		Name:      name,
 		Key:       key,
 		Channel:   formatTopic("", channel, options),
 		Subscribe: optionalHandler != nil,
-	})
+	}
 
+	// The link is restored with the original key, which may be resolved again
+	resolved := *req
+	if resolved.Key, err = c.resolveKey(ctx, key, channel, permissionsFor(linkPermissions, options)); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.request(ctx, "link", &resolved)
 	if err != nil {
 		return nil, err
 	}
@@ -447,6 +988,12 @@ func (c *Client) CreateLink(key, channel, name string, optionalHandler MessageHa
 	if result, ok := resp.(*Link); ok {
 		if optionalHandler != nil {
 			c.handlers.AddHandler(result.Channel, optionalHandler)
+			c.addSubscription(&subscription{
+				Key:     key,
+				Channel: result.Channel,
+				Handler: optionalHandler,
+				Link:    req,
+			})
 		}
 
 		return result, nil
@@ -454,105 +1001,147 @@ func (c *Client) CreateLink(key, channel, name string, optionalHandler MessageHa
 	return nil, ErrUnmarshal
 }
 
+// History returns an iterator over the messages stored in a channel between two unix
+// timestamps, up to the limit provided.
 func (c *Client) History(key, channel string, from, until int64, limit int) func(func(m HistoryMessage, err error) bool) {
-	return func(yield func(m HistoryMessage, err error) bool) {
-		{
-			var startFromID MessageID = nil
-			nMsgRetrieved := 0
-
-			for {
-				req := &historyRequest{
-					// As an MQTT message size is limited, we need to paginate the history request.
-					// If we didn't receive all messages (limit) we request limit - nMsgRetrieved messages.
-					// We also set the startFromID to the message ID of the last message we received to
-					// continue retrieving them from this one.
-					Channel:     formatTopic(key, channel, []Option{WithLast(limit - nMsgRetrieved), WithFrom(time.Unix(from, 0)), WithUntil(time.Unix(until, 0))}),
-					StartFromID: startFromID,
-				}
-
-				resp, err := c.request("history", req)
-				if err != nil {
-					yield(HistoryMessage{}, err)
-				}
-
-				// Cast the response.
-				result, _ := resp.(*historyResponse)
+	return c.HistoryContext(context.Background(), key, channel, from, until, limit)
+}
 
-				// If no messages left in the history then return.
-				if len(result.Messages) == 0 {
-					return
-				}
+// HistoryContext returns an iterator over the most recent messages stored in a channel
+// between two unix timestamps, up to the limit provided, or every message if the limit
+// is zero. A zero timestamp leaves the range open. The messages are retrieved one page
+// at a time from the most recent one, and each page is yielded in publication order, so
+// that at most one page is held in memory. Each page request honours the deadline and
+// cancellation of the context. See HistoryQuery for resumable queries.
+func (c *Client) HistoryContext(ctx context.Context, key, channel string, from, until int64, limit int) func(func(m HistoryMessage, err error) bool) {
+	q := c.HistoryQuery(key, channel).Limit(limit)
+	if from > 0 {
+		q = q.From(time.Unix(from, 0))
+	}
+	if until > 0 {
+		q = q.Until(time.Unix(until, 0))
+	}
 
-				// Yield each message returned by the history request.
-				for i := 0; i <= len(result.Messages)-1; i++ {
-					if !yield(result.Messages[i], nil) {
-						return
-					}
+	return func(yield func(m HistoryMessage, err error) bool) {
+		page := make([]HistoryMessage, 0, defaultPageSize) // From the most recent
+		flush := func() bool {
+			for i := len(page) - 1; i >= 0; i-- {
+				if !yield(page[i], nil) {
+					return false
 				}
+			}
+			page = page[:0]
+			return true
+		}
 
-				nMsgRetrieved += len(result.Messages)
-				// If we received the number of messages requested then return.
-				if nMsgRetrieved >= limit {
-					return
+		for m, err := range q.Messages(ctx) {
+			if err != nil {
+				if flush() {
+					yield(HistoryMessage{}, err)
 				}
+				return
+			}
 
-				// As an MQTT message size is limited, we need to paginate the history request.
-				// In case we have more messages to retrieve, set the startFromID to the message ID
-				// of the last message we received to continue retrieving them from this one.
-				startFromID = result.Messages[0].ID
+			if page = append(page, m); len(page) == defaultPageSize && !flush() {
+				return
 			}
 		}
+		flush()
 	}
 }
 
 // Makes a request
-func (c *Client) request(operation string, req interface{}) (Response, error) {
+func (c *Client) request(ctx context.Context, operation string, req interface{}) (resp Response, err error) {
 	request, err := json.Marshal(req)
 	if err != nil {
 		panic("unable to encode the request")
 	}
 
+	start := time.Now()
+	err = c.retry(ctx, operation, func() (err error) {
+		resp, err = c.exchange(ctx, operation, request)
+		return
+	})
+
+	c.metrics.Observe(MetricRequestDuration, since(start), Label{"operation", operation})
+	c.measure(operation, err)
+	return
+}
+
+// exchange publishes a request once and waits for its response.
+func (c *Client) exchange(ctx context.Context, operation string, request []byte) (Response, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
 	// Publish and wait for an error, response or puback
-	// The client is locked until the callback is stored, so the response
+	// The client is locked until the request is registered, so the response
 	// cannot arrive before and be lost
 	c.Lock()
 	token := c.conn.Publish(fmt.Sprintf("emitter/%s/", operation), 1, false, request)
-	respChan := c.store.PutCallback(token.(*mqtt.PublishToken).MessageID())
+	pending := c.requests.Register(operation, token.(*mqtt.PublishToken).MessageID())
 	c.Unlock()
-	if err := c.do(token); err != nil {
+	if err := c.do(ctx, token); err != nil {
+		c.requests.Cancel(pending, err)
 		return nil, err
 	}
-	resp := <-respChan
-	if err, ok := resp.(error); ok {
+
+	c.metrics.Add(MetricBytesSent, float64(len(request)))
+
+	// Wait for the response, abandoning the request if the caller gives up
+	select {
+	case r := <-pending.result:
+		return r.resp, r.err
+	case <-ctx.Done():
+		err := contextError(ctx)
+		c.requests.Cancel(pending, err)
 		return nil, err
 	}
-	return resp, nil
+}
+
+// RequestStats returns the counters of the requests made to the broker, such as the
+// number of requests currently awaiting a response.
+func (c *Client) RequestStats() RequestStats {
+	return c.requests.Stats()
+}
+
+// withTimeout derives a context which is bounded by the default timeout of the
+// client, unless the parent context already carries a deadline.
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, c.timeout)
 }
 
 // do waits for the operation to complete
-func (c *Client) do(t mqtt.Token) error {
-	if !t.WaitTimeout(c.timeout) {
+func (c *Client) do(ctx context.Context, t mqtt.Token) error {
+	select {
+	case <-t.Done():
+		return t.Error()
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+}
+
+// contextError converts the error of a context which is done, so that an expired
+// deadline is reported as ErrTimeout.
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
 		return ErrTimeout
 	}
 
-	return t.Error()
+	return ctx.Err()
 }
 
 // Makes a topic name from the key/channel pair
 func formatTopic(key, channel string, options []Option) string {
-	key = trim(key)
-	channel = trim(channel)
-	opts := formatOptions(options)
-	if len(key) == 0 {
-		return fmt.Sprintf("%s/%s", channel, opts)
-	}
-
-	return fmt.Sprintf("%s/%s/%s", key, channel, opts)
+	return NewChannel(channel).WithKey(key).WithOptions(options...).String()
 }
 
 // formatShare creates a shared topic subscription
 func formatShare(key, shareGroup, channel string, options []Option) string {
-	return fmt.Sprintf("%s/$share/%s/%s/%s", trim(key), trim(shareGroup), trim(channel), formatOptions(options))
+	return NewChannel(channel).WithKey(key).WithGroup(shareGroup).WithOptions(options...).String()
 }
 
 // getHeader gets the header fields from options.
@@ -1,26 +1,36 @@
 package emitter
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/emitter-io/go/v2/emittertest"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestEndToEnd(t *testing.T) {
-	clientA(t)
-	clientB(t)
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	clientA(t, srv.URL)
+	clientB(t, srv.URL)
 
 	// stop after 1 seconds
 	time.Sleep(1 * time.Second)
 }
 
-func clientA(t *testing.T) {
+func clientA(t *testing.T, broker string) {
 	const key = "HMauuTysKrOZPyHc9ANkMfJfeAD_QDgQ" // read on sdk-integration-test/#/
 
 	// Create the client and connect to the broker
-	c, _ := Connect("tcp://localhost:8080", func(_ *Client, msg Message) {
+	c, _ := Connect(broker, func(_ *Client, msg Message) {
 		fmt.Printf("[emitter] -> [A] received: '%s' topic: '%s'\n", msg.Payload(), msg.Topic())
 	})
 
@@ -30,11 +40,11 @@ func clientA(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func clientB(t *testing.T) {
+func clientB(t *testing.T, broker string) {
 	const key = "HMauuTysKrOZPyHc9ANkMfJfeAD_QDgQ" // everything on sdk-integration-test/
 
 	// Create the client and connect to the broker
-	c, _ := Connect("tcp://localhost:8080", func(_ *Client, msg Message) {
+	c, _ := Connect(broker, func(_ *Client, msg Message) {
 		fmt.Printf("[emitter] -> [B] received: '%s' topic: '%s'\n", msg.Payload(), msg.Topic())
 	})
 
@@ -138,22 +148,229 @@ func TestPresence(t *testing.T) {
 	})
 
 	assert.Equal(t, 2, len(events))
+	assert.Equal(t, PresenceUnsubscribe, events[0].Event)
+	assert.Equal(t, []PresenceInfo{{ID: "B"}, {ID: "C"}}, events[0].Who)
+	assert.Equal(t, PresenceSubscribe, events[1].Event)
+	assert.Equal(t, []PresenceInfo{{ID: "A"}}, events[1].Who)
+	assert.Equal(t, time.Unix(1589626821, 0), events[1].Time)
+}
+
+func TestResubscribe(t *testing.T) {
+	const key = "resubscribe-key"
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	received := make(chan string, 10)
+	connected := make(chan struct{}, 2)
+	c := NewClient(WithBrokers(srv.URL), WithResubscribeHistory(false))
+	c.OnConnect(func(_ *Client) { connected <- struct{}{} })
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+	<-connected
+
+	assert.NoError(t, c.Publish(key, "resub/", "stored", WithTTL(60)))
+	assert.NoError(t, c.Subscribe(key, "resub/", func(_ *Client, m Message) {
+		received <- string(m.Payload())
+	}, WithLast(1)))
+	assert.Equal(t, "stored", <-received)
+
+	// Drop the connection and wait for the automatic reconnection
+	srv.CloseClientConnections()
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the reconnection")
+	}
+
+	// The subscription is restored without retrieving the history again
+	assert.NoError(t, c.Publish(key, "resub/", "live"))
+	select {
+	case msg := <-received:
+		assert.Equal(t, "live", msg)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	// Generated keys cannot generate keys, the error is returned without an error handler
+	key, _, err := c.GenerateKey("master-key", "a/", "r", 0)
+	assert.NoError(t, err)
+	_, _, err = c.GenerateKey(key, "a/", "r", 0)
+	assert.Equal(t, 401, err.(*Error).Status)
+
+	stats := c.RequestStats()
+	assert.Equal(t, 0, stats.Outstanding)
+	assert.Equal(t, uint64(1), stats.Completed)
+	assert.Equal(t, uint64(1), stats.Failed)
+}
+
+func TestRequestRemoved(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	// A blocked handler stalls the responses, which are received on the same goroutine
+	release := make(chan struct{})
+	blocked := make(chan struct{})
+	c, err := Connect(srv.URL, func(*Client, Message) {
+		close(blocked)
+		<-release
+	})
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	assert.NoError(t, c.Subscribe("master-key", "a/", nil))
+	assert.NoError(t, c.Publish("master-key", "a/", "block"))
+	<-blocked
+
+	// The requests which time out or are canceled are no longer awaiting a response
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = c.GenerateKeyContext(ctx, "master-key", "a/", "r", 0)
+	assert.Equal(t, ErrTimeout, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, _, err = c.GenerateKeyContext(ctx, "master-key", "a/", "r", 0)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	stats := c.RequestStats()
+	assert.Equal(t, 0, stats.Outstanding)
+	assert.Equal(t, uint64(1), stats.TimedOut)
+	assert.Equal(t, uint64(1), stats.Canceled)
+	close(release)
+}
+
+func TestLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	c := NewClient(WithLogger(slog.New(slog.NewJSONHandler(&buffer, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+
+	c.onMessage(nil, &message{topic: "emitter/keygen/", payload: `{"req":7,"key":"abc"}`})
+	c.onMessage(nil, &message{topic: "emitter/presence/", payload: `not json`})
+	c.onMessage(nil, &message{topic: "emitter/error/", payload: `{"status":401,"message":"unauthorized"}`})
+	c.onMessage(nil, &message{topic: "a/b/", payload: `hello`})
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+
+	assert.Len(t, entries, 4)
+	assert.Equal(t, "emitter/keygen/", entries[0]["topic"])
+	assert.Equal(t, float64(7), entries[0]["req"])
+	assert.Equal(t, "ERROR", entries[1]["level"])
+	assert.Equal(t, float64(401), entries[2]["status"])
+	assert.Equal(t, "DEBUG", entries[3]["level"])
+	assert.Equal(t, "a/b/", entries[3]["topic"])
+
+	// A nil logger discards the diagnostics
+	c = NewClient(WithLogger(nil))
+	c.onMessage(nil, &message{topic: "emitter/keygen/", payload: `{"req":7}`})
+}
+
+// pendingToken is a token which never completes.
+type pendingToken struct {
+	done chan struct{}
+}
+
+func (t *pendingToken) Wait() bool                     { <-t.done; return true }
+func (t *pendingToken) WaitTimeout(time.Duration) bool { return false }
+func (t *pendingToken) Done() <-chan struct{}          { return t.done }
+func (t *pendingToken) Error() error                   { return nil }
+
+func TestDoContext(t *testing.T) {
+	c := NewClient()
+	token := &pendingToken{done: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, c.do(ctx, token))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.Equal(t, ErrTimeout, c.do(ctx, token))
+
+	close(token.done)
+	assert.NoError(t, c.do(context.Background(), token))
+}
+
+func TestWithTimeout(t *testing.T) {
+	c := NewClient()
+	c.timeout = time.Second
+
+	ctx, cancel := c.withTimeout(context.Background())
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+
+	parent, cancelParent := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelParent()
+	ctx, cancel = c.withTimeout(parent)
+	defer cancel()
+	deadline, _ = ctx.Deadline()
+	assert.WithinDuration(t, time.Now().Add(time.Hour), deadline, time.Second)
 }
 
-/*
 func TestHistory(t *testing.T) {
-	c, err := Connect("tcp://localhost:8080", nil)
+	const key = "JN8kaVOZQtG-G6QHnbFzcI-uyS_M3L5q"
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
 	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, c.Publish(key, "test/", fmt.Sprintf("Hello World%d", i), WithTTL(60)))
+	}
 
-	for messageHistory, err := range c.History("JN8kaVOZQtG-G6QHnbFzcI-uyS_M3L5q", "test/", 1685608812, 1757293928, 5) {
-		if err != nil {
-			fmt.Println(err)
+	var payloads []string
+	for messageHistory, err := range c.History(key, "test/", 0, time.Now().Add(time.Minute).Unix(), 5) {
+		if !assert.NoError(t, err) {
 			break
 		}
-		fmt.Println(messageHistory)
-		if messageHistory.Payload == "Hello World3" {
+		payloads = append(payloads, string(messageHistory.Payload))
+		if string(messageHistory.Payload) == "Hello World2" {
 			break
 		}
 	}
 
-}*/
+	assert.Equal(t, []string{"Hello World1", "Hello World2"}, payloads)
+}
+
+func TestHistoryPages(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	const count = defaultPageSize + defaultPageSize/2
+	for i := 0; i < count; i++ {
+		assert.NoError(t, c.Publish("key", "pages/", strconv.Itoa(i), WithTTL(60)))
+	}
+
+	// The most recent page comes first, each page being in publication order
+	var payloads []string
+	for m, err := range c.History("key", "pages/", 0, 0, 0) {
+		assert.NoError(t, err)
+		payloads = append(payloads, string(m.Payload))
+	}
+
+	assert.Len(t, payloads, count)
+	assert.Equal(t, strconv.Itoa(count-defaultPageSize), payloads[0])
+	assert.Equal(t, strconv.Itoa(count-1), payloads[defaultPageSize-1])
+	assert.Equal(t, "0", payloads[defaultPageSize])
+	assert.Equal(t, strconv.Itoa(count-defaultPageSize-1), payloads[count-1])
+}
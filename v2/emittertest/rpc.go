@@ -0,0 +1,413 @@
+package emittertest
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// apiError represents an error replied on the "emitter/error/" topic.
+type apiError struct {
+	Request uint16 `json:"req,omitempty"`
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// Error returns the error message.
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// The errors replied by the server, matching the ones of the emitter broker
+var (
+	errBadRequest   = &apiError{Status: 400, Message: "the request was invalid or cannot be otherwise served"}
+	errUnauthorized = &apiError{Status: 401, Message: "the security key provided is not authorized to perform this operation"}
+	errNotFound     = &apiError{Status: 404, Message: "the resource requested does not exist"}
+)
+
+// ------------------------------------------------------------------------------------
+
+type keygenRequest struct {
+	Key     string `json:"key"`
+	Channel string `json:"channel"`
+	Type    string `json:"type"`
+	TTL     int    `json:"ttl"`
+}
+
+type keygenResponse struct {
+	Request uint16 `json:"req,omitempty"`
+	Status  int    `json:"status"`
+	Key     string `json:"key"`
+	Channel string `json:"channel"`
+}
+
+type keybanRequest struct {
+	Secret string `json:"secret"`
+	Target string `json:"target"`
+	Banned bool   `json:"banned"`
+}
+
+type keybanResponse struct {
+	Request uint16 `json:"req,omitempty"`
+	Status  int    `json:"status"`
+	Banned  bool   `json:"banned"`
+}
+
+type presenceRequest struct {
+	Key     string `json:"key"`
+	Channel string `json:"channel"`
+	Status  bool   `json:"status"`
+	Changes bool   `json:"changes"`
+}
+
+type presenceInfo struct {
+	ID       string `json:"id"`
+	Username string `json:"username,omitempty"`
+}
+
+type presenceStatus struct {
+	Request uint16         `json:"req,omitempty"`
+	Event   string         `json:"event"`
+	Channel string         `json:"channel"`
+	Time    int64          `json:"time"`
+	Who     []presenceInfo `json:"who"`
+}
+
+type presenceEvent struct {
+	Event   string       `json:"event"`
+	Channel string       `json:"channel"`
+	Time    int64        `json:"time"`
+	Who     presenceInfo `json:"who"`
+}
+
+type linkRequest struct {
+	Name      string `json:"name"`
+	Key       string `json:"key"`
+	Channel   string `json:"channel"`
+	Subscribe bool   `json:"subscribe"`
+}
+
+type linkResponse struct {
+	Request uint16 `json:"req,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Channel string `json:"channel,omitempty"`
+}
+
+type meResponse struct {
+	Request uint16            `json:"req,omitempty"`
+	ID      string            `json:"id"`
+	Links   map[string]string `json:"links,omitempty"`
+}
+
+type historyRequest struct {
+	Channel     string `json:"channel"`
+	StartFromID []byte `json:"startFromID"`
+}
+
+type historyMessage struct {
+	ID      []byte `json:"id"`
+	Channel string `json:"channel"`
+	Payload []byte `json:"payload"`
+}
+
+type historyResponse struct {
+	Request  uint16           `json:"req,omitempty"`
+	Messages []historyMessage `json:"messages"`
+}
+
+// ------------------------------------------------------------------------------------
+
+// onRequest processes a request published on an "emitter/" topic. The request ID
+// used for the correlation is the MQTT packet ID of the request.
+func (c *conn) onRequest(p *packets.PublishPacket) {
+	var (
+		resp interface{}
+		err  error
+	)
+
+	switch strings.Trim(strings.TrimPrefix(p.TopicName, "emitter/"), "/") {
+	case "keygen":
+		resp, err = c.onKeygen(p.MessageID, p.Payload)
+	case "keyban":
+		resp, err = c.onKeyban(p.MessageID, p.Payload)
+	case "presence":
+		resp, err = c.onPresence(p.MessageID, p.Payload)
+	case "link":
+		resp, err = c.onLink(p.MessageID, p.Payload)
+	case "me":
+		resp, err = c.onMe(p.MessageID)
+	case "history":
+		resp, err = c.onHistory(p.MessageID, p.Payload)
+	default:
+		err = errNotFound
+	}
+
+	switch {
+	case err != nil:
+		c.sendError(p.MessageID, err)
+	case resp != nil:
+		c.send(p.TopicName, mustMarshal(resp))
+	}
+}
+
+// sendError replies an error to the client.
+func (c *conn) sendError(id uint16, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		e = &apiError{Status: 500, Message: err.Error()}
+	}
+
+	reply := *e
+	reply.Request = id
+	c.send("emitter/error/", mustMarshal(&reply))
+}
+
+// onKeygen generates a new key.
+func (c *conn) onKeygen(id uint16, payload []byte) (interface{}, error) {
+	var req keygenRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, errBadRequest
+	}
+
+	t, err := parseTopic(req.Channel, false)
+	if err != nil || req.TTL < 0 {
+		return nil, errBadRequest
+	}
+
+	for _, p := range req.Type {
+		if !strings.ContainsRune(permissions, p) {
+			return nil, errBadRequest
+		}
+	}
+
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isMaster(req.Key) {
+		return nil, errUnauthorized
+	}
+
+	// Keys granted on a wildcard channel keep the trailing wildcard
+	channel := t.Channel
+	if strings.HasSuffix(strings.TrimSuffix(req.Channel, "/"), "#") {
+		channel += "#/"
+	}
+
+	k := &key{Channel: channel, Permissions: req.Type}
+	if req.TTL > 0 {
+		k.Expires = time.Now().Add(time.Duration(req.TTL) * time.Second)
+	}
+
+	generated := newID()
+	s.keys[generated] = k
+	return &keygenResponse{
+		Request: id,
+		Status:  200,
+		Key:     generated,
+		Channel: channel,
+	}, nil
+}
+
+// onKeyban bans or allows a key.
+func (c *conn) onKeyban(id uint16, payload []byte) (interface{}, error) {
+	var req keybanRequest
+	if err := json.Unmarshal(payload, &req); err != nil || req.Target == "" {
+		return nil, errBadRequest
+	}
+
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isMaster(req.Secret) {
+		return nil, errUnauthorized
+	}
+
+	if req.Banned {
+		s.banned[req.Target] = true
+	} else {
+		delete(s.banned, req.Target)
+	}
+
+	return &keybanResponse{
+		Request: id,
+		Status:  200,
+		Banned:  req.Banned,
+	}, nil
+}
+
+// onPresence replies the presence status of a channel and enables or disables the
+// change notifications. The status lists nobody unless it was requested.
+func (c *conn) onPresence(id uint16, payload []byte) (interface{}, error) {
+	var req presenceRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, errBadRequest
+	}
+
+	t, err := parseTopic(req.Channel, false)
+	if err != nil {
+		return nil, errBadRequest
+	}
+
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.authorize(req.Key, t.Channel, 'p'); err != nil {
+		return nil, err
+	}
+
+	if req.Changes {
+		c.presence[t.Channel] = true
+	} else {
+		delete(c.presence, t.Channel)
+	}
+
+	// Like the broker, reply a status listing nobody when it was not requested
+	who := make([]presenceInfo, 0)
+	for other := range s.conns {
+		for _, sub := range other.subs {
+			if req.Status && sub.Channel == t.Channel {
+				who = append(who, other.info())
+				break
+			}
+		}
+	}
+
+	return &presenceStatus{
+		Request: id,
+		Event:   "status",
+		Channel: t.Channel,
+		Time:    time.Now().Unix(),
+		Who:     who,
+	}, nil
+}
+
+// onLink creates a link and optionally subscribes the client to it.
+func (c *conn) onLink(id uint16, payload []byte) (interface{}, error) {
+	var req linkRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, errBadRequest
+	}
+
+	t, err := parseTopic(req.Channel, false)
+	if err != nil || len(req.Name) == 0 || len(req.Name) > 2 {
+		return nil, errBadRequest
+	}
+
+	s := c.server
+	s.mu.Lock()
+	permission := byte('w')
+	if req.Subscribe {
+		permission = 'r'
+	}
+
+	if err := s.authorize(req.Key, t.Channel, permission); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	c.links[req.Name] = &link{Key: req.Key, Channel: strings.TrimPrefix(req.Channel, "/")}
+	s.mu.Unlock()
+
+	if req.Subscribe {
+		_, deliver := c.subscribe(req.Key + "/" + strings.TrimPrefix(req.Channel, "/"))
+		defer deliver()
+	}
+
+	return &linkResponse{
+		Request: id,
+		Name:    req.Name,
+		Channel: t.Channel,
+	}, nil
+}
+
+// onMe replies the information about the client.
+func (c *conn) onMe(id uint16) (interface{}, error) {
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	links := make(map[string]string, len(c.links))
+	for name, l := range c.links {
+		links[name] = l.Channel
+	}
+
+	return &meResponse{
+		Request: id,
+		ID:      c.id,
+		Links:   links,
+	}, nil
+}
+
+// onHistory replies the messages stored in a channel.
+func (c *conn) onHistory(id uint16, payload []byte) (interface{}, error) {
+	var req historyRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, errBadRequest
+	}
+
+	t, err := parseTopic(req.Channel, true)
+	if err != nil {
+		return nil, errBadRequest
+	}
+
+	last, ok1 := t.Int("last", 1)
+	from, ok2 := t.Time("from")
+	until, ok3 := t.Time("until")
+	if !ok1 || !ok2 || !ok3 || last < 0 {
+		return nil, errBadRequest
+	}
+
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.authorize(t.Key, t.Channel, 'l'); err != nil {
+		return nil, err
+	}
+
+	messages := make([]historyMessage, 0)
+	for _, m := range s.history(t.Channel, from, until, req.StartFromID, int(last)) {
+		messages = append(messages, historyMessage{
+			ID:      m.ID,
+			Channel: m.Channel,
+			Payload: m.Payload,
+		})
+	}
+
+	return &historyResponse{
+		Request:  id,
+		Messages: messages,
+	}, nil
+}
+
+// ------------------------------------------------------------------------------------
+
+// The permission letters supported by emitter keys
+const permissions = "rwslpex"
+
+// hasPermission checks whether a set of permission letters contains a permission.
+func hasPermission(granted string, permission byte) bool {
+	return strings.IndexByte(granted, permission) >= 0
+}
+
+// covers checks whether the channel pattern of a key covers a channel. Patterns
+// ending with a multi-level wildcard cover every sub-channel.
+func covers(pattern, channel string) bool {
+	if strings.HasSuffix(pattern, "#/") {
+		return matches(strings.TrimSuffix(pattern, "#/"), channel)
+	}
+
+	p := strings.Split(strings.TrimSuffix(pattern, "/"), "/")
+	c := strings.Split(strings.TrimSuffix(channel, "/"), "/")
+	return len(p) == len(c) && matches(pattern, channel)
+}
+
+// mustMarshal encodes a value in JSON.
+func mustMarshal(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,550 @@
+// Package emittertest provides an in-process stand-in for the emitter broker, so
+// that code built on the emitter SDK can be tested without network access.
+//
+// The server speaks MQTT 3.1.1 and implements the emitter dialect: keyed topics
+// with options, share groups, retained messages, message storage with "last"
+// and history queries, links, presence, key generation and key banning. Keys
+// which were not generated by the server are treated as master keys and are
+// granted every permission.
+package emittertest
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// Server is an emitter broker stand-in listening on a local port.
+type Server struct {
+	URL      string       // The broker address, of the form tcp://127.0.0.1:port
+	Listener net.Listener // The listener accepting connections
+
+	mu       sync.Mutex
+	conns    map[*conn]struct{}  // The connected clients
+	keys     map[string]*key     // The keys generated by the server
+	banned   map[string]bool     // The keys which were banned
+	retained map[string]*message // The retained messages, per channel
+	stored   []*message          // The stored messages, in publication order
+	rotation uint64              // The counter used to pick share group members
+	wg       sync.WaitGroup
+}
+
+// key represents a key generated by the server.
+type key struct {
+	Channel     string    // The channel pattern the key is valid for
+	Permissions string    // The permission letters granted by the key
+	Expires     time.Time // The expiry time, zero if the key never expires
+}
+
+// message represents a message stored by the server.
+type message struct {
+	ID      []byte    // The unique, ordered identifier of the message
+	Channel string    // The channel the message was published on
+	Payload []byte    // The payload of the message
+	Time    time.Time // The time of publication
+	Expires time.Time // The expiry time of the message
+}
+
+// NewServer starts and returns a new server listening on a random local port. The
+// caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("emittertest: failed to listen on a port: %v", err))
+	}
+
+	s := &Server{
+		URL:      "tcp://" + l.Addr().String(),
+		Listener: l,
+		conns:    make(map[*conn]struct{}),
+		keys:     make(map[string]*key),
+		banned:   make(map[string]bool),
+		retained: make(map[string]*message),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Close shuts down the server and closes every client connection.
+func (s *Server) Close() {
+	s.Listener.Close()
+	s.CloseClientConnections()
+	s.wg.Wait()
+}
+
+// CloseClientConnections closes every client connection, without shutting down
+// the server. This is useful to simulate a connection loss.
+func (s *Server) CloseClientConnections() {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.socket.Close()
+	}
+}
+
+// Clients returns the number of clients currently connected.
+func (s *Server) Clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// serve accepts the incoming connections.
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		socket, err := s.Listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := newConn(s, socket)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+		}()
+	}
+}
+
+// register adds a connection to the server once it sent a CONNECT packet.
+func (s *Server) register(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[c] = struct{}{}
+}
+
+// unregister removes a connection from the server and notifies the presence
+// subscribers of its departure.
+func (s *Server) unregister(c *conn) {
+	s.mu.Lock()
+	if _, ok := s.conns[c]; !ok {
+		s.mu.Unlock()
+		return
+	}
+
+	delete(s.conns, c)
+	var channels []string
+	for _, sub := range c.subs {
+		channels = append(channels, sub.Channel)
+	}
+	s.mu.Unlock()
+
+	for _, channel := range channels {
+		s.notifyPresence("unsubscribe", channel, c)
+	}
+}
+
+// nextID generates a new message identifier. The identifiers are random, so that the
+// clients rely on the order of the history rather than on the one of the identifiers.
+func (s *Server) nextID() []byte {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// authorize checks whether a key allows an operation on a channel. The server
+// lock must be held by the caller.
+func (s *Server) authorize(k, channel string, permission byte) error {
+	if k == "" || s.banned[k] {
+		return errUnauthorized
+	}
+
+	generated, ok := s.keys[k]
+	if !ok {
+		return nil // Unknown keys are master keys
+	}
+
+	if !generated.Expires.IsZero() && time.Now().After(generated.Expires) {
+		return errUnauthorized
+	}
+
+	if !covers(generated.Channel, channel) || !hasPermission(generated.Permissions, permission) {
+		return errUnauthorized
+	}
+	return nil
+}
+
+// isMaster checks whether a key is a master key. The server lock must be held by
+// the caller.
+func (s *Server) isMaster(k string) bool {
+	_, generated := s.keys[k]
+	return k != "" && !generated && !s.banned[k]
+}
+
+// publish stores and routes a message published by a client.
+func (s *Server) publish(from *conn, t *topic, payload []byte, retain bool) error {
+	ttl, ok := t.Int("ttl", 0)
+	if !ok || ttl < 0 {
+		return errBadRequest
+	}
+
+	s.mu.Lock()
+	if err := s.authorize(t.Key, t.Channel, 'w'); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	now := time.Now()
+	msg := &message{
+		ID:      s.nextID(),
+		Channel: t.Channel,
+		Payload: payload,
+		Time:    now,
+	}
+
+	// Store the message if requested and allowed
+	if (ttl > 0 || retain) && s.authorize(t.Key, t.Channel, 's') == nil {
+		switch {
+		case retain:
+			msg.Expires = now.Add(100 * 365 * 24 * time.Hour)
+			s.retained[t.Channel] = msg
+		default:
+			msg.Expires = now.Add(time.Duration(ttl) * time.Second)
+		}
+		s.stored = append(s.stored, msg)
+	}
+
+	// Select the recipients, picking a single member for each share group
+	echo := t.Options.Get("me") != "0"
+	var targets []*conn
+	groups := make(map[string][]*conn)
+	for c := range s.conns {
+		if c == from && !echo {
+			continue
+		}
+
+		for _, sub := range c.subs {
+			if !matches(sub.Channel, t.Channel) {
+				continue
+			}
+
+			if sub.Group != "" {
+				groups[sub.Group] = append(groups[sub.Group], c)
+				continue
+			}
+
+			targets = append(targets, c)
+			break
+		}
+	}
+
+	for _, members := range groups {
+		s.rotation++
+		targets = append(targets, members[s.rotation%uint64(len(members))])
+	}
+	s.mu.Unlock()
+
+	for _, c := range targets {
+		c.send(t.Channel, payload)
+	}
+	return nil
+}
+
+// history returns the stored messages matching a channel, in publication order. The
+// server lock must be held by the caller.
+func (s *Server) history(channel string, from, until time.Time, before []byte, limit int) []*message {
+	now := time.Now()
+	start := len(s.stored)
+	if before != nil {
+		start = s.position(before)
+	}
+
+	var result []*message
+	for i := start - 1; i >= 0 && len(result) < limit; i-- {
+		m := s.stored[i]
+		switch {
+		case now.After(m.Expires):
+		case !matches(channel, m.Channel):
+		case !from.IsZero() && m.Time.Before(from):
+		case !until.IsZero() && m.Time.After(until):
+		default:
+			result = append(result, m)
+		}
+	}
+
+	// Reverse, so messages are in publication order
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// position returns the index of a stored message, or zero if it is not stored, so that
+// no message is found before it. The server lock must be held by the caller.
+func (s *Server) position(id []byte) int {
+	for i, m := range s.stored {
+		if string(m.ID) == string(id) {
+			return i
+		}
+	}
+	return 0
+}
+
+// notifyPresence sends a presence change event to every client which requested
+// presence notifications on the channel.
+func (s *Server) notifyPresence(event, channel string, who *conn) {
+	payload := mustMarshal(&presenceEvent{
+		Event:   event,
+		Channel: channel,
+		Time:    time.Now().Unix(),
+		Who:     who.info(),
+	})
+
+	s.mu.Lock()
+	var targets []*conn
+	for c := range s.conns {
+		if c.presence[channel] {
+			targets = append(targets, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range targets {
+		c.send("emitter/presence/", payload)
+	}
+}
+
+// newID generates a random connection identifier.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%X", b)
+}
+
+// ------------------------------------------------------------------------------------
+
+// conn represents a connected client.
+type conn struct {
+	server   *Server
+	socket   net.Conn
+	id       string                   // The emitter connection ID
+	username string                   // The MQTT username
+	wmu      sync.Mutex               // Serializes the writes
+	subs     map[string]*subscription // The subscriptions, by topic
+	links    map[string]*link         // The links, by name
+	presence map[string]bool          // The channels with presence notifications
+}
+
+// subscription represents a subscription of a client.
+type subscription struct {
+	Channel string // The channel pattern
+	Group   string // The share group, if any
+}
+
+// link represents a link created by a client.
+type link struct {
+	Key     string // The key to use
+	Channel string // The channel, with its options
+}
+
+// newConn creates a new connection.
+func newConn(s *Server, socket net.Conn) *conn {
+	return &conn{
+		server:   s,
+		socket:   socket,
+		id:       newID(),
+		subs:     make(map[string]*subscription),
+		links:    make(map[string]*link),
+		presence: make(map[string]bool),
+	}
+}
+
+// serve reads and processes the packets sent by the client.
+func (c *conn) serve() {
+	defer c.server.unregister(c)
+	defer c.socket.Close()
+
+	for {
+		packet, err := packets.ReadPacket(c.socket)
+		if err != nil {
+			return
+		}
+
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			c.username = p.Username
+			c.server.register(c)
+			ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			ack.ReturnCode = packets.Accepted
+			c.write(ack)
+
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			var pending []func()
+			for _, t := range p.Topics {
+				code, deliver := c.subscribe(t)
+				ack.ReturnCodes = append(ack.ReturnCodes, code)
+				pending = append(pending, deliver)
+			}
+
+			c.write(ack)
+			for _, deliver := range pending {
+				deliver()
+			}
+
+		case *packets.UnsubscribePacket:
+			for _, t := range p.Topics {
+				c.unsubscribe(t)
+			}
+
+			ack := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ack.MessageID = p.MessageID
+			c.write(ack)
+
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				c.write(ack)
+			}
+			c.onPublish(p)
+
+		case *packets.PingreqPacket:
+			c.write(packets.NewControlPacket(packets.Pingresp))
+
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+// write sends a packet to the client.
+func (c *conn) write(p packets.ControlPacket) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := p.Write(c.socket); err != nil {
+		c.socket.Close()
+	}
+}
+
+// send sends a message to the client.
+func (c *conn) send(topic string, payload []byte) {
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = topic
+	p.Payload = payload
+	c.write(p)
+}
+
+// info returns the presence information of the client.
+func (c *conn) info() presenceInfo {
+	return presenceInfo{ID: c.id, Username: c.username}
+}
+
+// subscribe subscribes the client to a topic and returns the MQTT return code and a
+// function which delivers the retained and the requested stored messages.
+func (c *conn) subscribe(text string) (byte, func()) {
+	s := c.server
+	t, err := parseTopic(text, true)
+	if err != nil {
+		c.sendError(0, errBadRequest)
+		return 0x80, func() {}
+	}
+
+	last, ok := t.Int("last", 0)
+	if !ok || last < 0 {
+		c.sendError(0, errBadRequest)
+		return 0x80, func() {}
+	}
+
+	s.mu.Lock()
+	if err := s.authorize(t.Key, t.Channel, 'r'); err != nil {
+		s.mu.Unlock()
+		c.sendError(0, err)
+		return 0x80, func() {}
+	}
+
+	_, exists := c.subs[text]
+	c.subs[text] = &subscription{Channel: t.Channel, Group: t.Group}
+
+	// Collect the messages to deliver
+	var messages []*message
+	if last > 0 && s.authorize(t.Key, t.Channel, 'l') == nil {
+		from, _ := t.Time("from")
+		until, _ := t.Time("until")
+		messages = s.history(t.Channel, from, until, nil, int(last))
+	} else {
+		for _, m := range s.retained {
+			if matches(t.Channel, m.Channel) {
+				messages = append(messages, m)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	return 0, func() {
+		if !exists {
+			s.notifyPresence("subscribe", t.Channel, c)
+		}
+
+		for _, m := range messages {
+			c.send(m.Channel, m.Payload)
+		}
+	}
+}
+
+// unsubscribe removes a subscription of the client.
+func (c *conn) unsubscribe(text string) {
+	s := c.server
+	t, err := parseTopic(text, true)
+	if err != nil {
+		return
+	}
+
+	// Unsubscribe requests may carry options, match on the channel only
+	s.mu.Lock()
+	var removed bool
+	for k, sub := range c.subs {
+		if sub.Channel == t.Channel && sub.Group == t.Group {
+			delete(c.subs, k)
+			removed = true
+		}
+	}
+	s.mu.Unlock()
+
+	if removed {
+		s.notifyPresence("unsubscribe", t.Channel, c)
+	}
+}
+
+// onPublish processes a message published by the client.
+func (c *conn) onPublish(p *packets.PublishPacket) {
+	if len(p.TopicName) > len("emitter/") && p.TopicName[:len("emitter/")] == "emitter/" {
+		c.onRequest(p)
+		return
+	}
+
+	// Resolve the link, if the topic is a link name
+	text := p.TopicName
+	c.server.mu.Lock()
+	if l, ok := c.links[text]; ok {
+		text = l.Key + "/" + l.Channel
+	}
+	c.server.mu.Unlock()
+
+	t, err := parseTopic(text, true)
+	if err != nil {
+		c.sendError(0, errBadRequest)
+		return
+	}
+
+	if err := c.server.publish(c, t, p.Payload, p.Retain); err != nil {
+		c.sendError(0, err)
+	}
+}
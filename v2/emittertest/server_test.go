@@ -0,0 +1,196 @@
+package emittertest_test
+
+import (
+	"testing"
+	"time"
+
+	emitter "github.com/emitter-io/go/v2"
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+const master = "master-key"
+
+// connect creates a client connected to the server.
+func connect(t *testing.T, srv *emittertest.Server) (*emitter.Client, chan emitter.Message) {
+	received := make(chan emitter.Message, 16)
+	c, err := emitter.Connect(srv.URL, func(_ *emitter.Client, m emitter.Message) {
+		received <- m
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Disconnect(0) })
+	return c, received
+}
+
+// receive waits for a message to be received.
+func receive(t *testing.T, received chan emitter.Message) string {
+	select {
+	case m := <-received:
+		return string(m.Payload())
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a message")
+		return ""
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	a, received := connect(t, srv)
+	assert.NoError(t, a.Subscribe(master, "a/", nil))
+	assert.NoError(t, a.Publish(master, "a/b/", "hello"))
+	assert.Equal(t, "hello", receive(t, received))
+
+	// Without echo, the publisher does not receive its own message
+	assert.NoError(t, a.Publish(master, "a/", "hidden", emitter.WithoutEcho()))
+	assert.NoError(t, a.Publish(master, "a/", "visible"))
+	assert.Equal(t, "visible", receive(t, received))
+}
+
+func TestRetainedAndLast(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	a, _ := connect(t, srv)
+	assert.NoError(t, a.PublishWithRetain(master, "r/", "retained"))
+	assert.NoError(t, a.PublishWithTTL(master, "s/", "one", 60))
+	assert.NoError(t, a.PublishWithTTL(master, "s/", "two", 60))
+	assert.NoError(t, a.Publish(master, "s/", "not stored"))
+
+	b, received := connect(t, srv)
+	assert.NoError(t, b.Subscribe(master, "r/", nil))
+	assert.Equal(t, "retained", receive(t, received))
+
+	assert.NoError(t, b.SubscribeWithHistory(master, "s/", 5, nil))
+	assert.Equal(t, "one", receive(t, received))
+	assert.Equal(t, "two", receive(t, received))
+}
+
+func TestShareGroup(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	a, receivedA := connect(t, srv)
+	b, receivedB := connect(t, srv)
+	assert.NoError(t, a.SubscribeWithGroup(master, "g/", "workers", nil))
+	assert.NoError(t, b.SubscribeWithGroup(master, "g/", "workers", nil))
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, a.Publish(master, "g/", "work"))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 4, len(receivedA)+len(receivedB))
+}
+
+func TestKeys(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	a, received := connect(t, srv)
+	errors := make(chan emitter.Error, 1)
+	a.OnError(func(_ *emitter.Client, e emitter.Error) {
+		errors <- e
+	})
+
+	key, channel, err := a.GenerateKey(master, "k/#/", "r", 0)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, key)
+	assert.Equal(t, "k/#/", channel)
+
+	// The generated key can read but not write, nor generate keys
+	assert.NoError(t, a.Subscribe(key, "k/x/", nil))
+	_, _, err = a.GenerateKey(key, "k/", "r", 0)
+	assert.Error(t, err)
+
+	// Banned keys are rejected
+	banned, err := a.BlockKey(master, key)
+	assert.NoError(t, err)
+	assert.True(t, banned)
+	assert.NoError(t, a.Subscribe(key, "k/y/", nil))
+	select {
+	case e := <-errors:
+		assert.Equal(t, 401, e.Status)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an error")
+	}
+
+	allowed, err := a.AllowKey(master, key)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	assert.NoError(t, a.Publish(master, "k/x/", "hello"))
+	assert.Equal(t, "hello", receive(t, received))
+}
+
+func TestLinkAndMe(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	a, _ := connect(t, srv)
+	received := make(chan emitter.Message, 1)
+	link, err := a.CreateLink(master, "l/", "x", func(_ *emitter.Client, m emitter.Message) {
+		received <- m
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "x", link.Name)
+	assert.Equal(t, "l/", link.Channel)
+
+	assert.NoError(t, a.PublishWithLink("x", "linked"))
+	assert.Equal(t, "linked", receive(t, received))
+	assert.NotEmpty(t, a.ID())
+}
+
+func TestPresence(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	a, _ := connect(t, srv)
+	events := make(chan emitter.PresenceEvent, 1)
+	a.OnPresence(func(_ *emitter.Client, ev emitter.PresenceEvent) {
+		events <- ev
+	})
+
+	status, err := a.Presence(master, "p/", true, true)
+	assert.NoError(t, err)
+	assert.Empty(t, status.Who)
+
+	b, _ := connect(t, srv)
+	assert.NoError(t, b.Subscribe(master, "p/", nil))
+	select {
+	case ev := <-events:
+		assert.Equal(t, emitter.PresenceSubscribe, ev.Event)
+		assert.Equal(t, b.ID(), ev.Who[0].ID)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a presence event")
+	}
+
+	// Without the status, the reply lists nobody
+	status, err = a.Presence(master, "p/", false, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "p/", status.Channel)
+	assert.Empty(t, status.Who)
+}
+
+func TestCloseClientConnections(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	lost := make(chan error, 1)
+	c := emitter.NewClient(emitter.WithBrokers(srv.URL))
+	c.OnDisconnect(func(_ *emitter.Client, err error) {
+		lost <- err
+	})
+
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+	assert.Equal(t, 1, srv.Clients())
+
+	srv.CloseClientConnections()
+	select {
+	case err := <-lost:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the connection loss")
+	}
+}
@@ -0,0 +1,112 @@
+package emittertest
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Various errors which are returned when parsing a topic
+var (
+	errBadTopic   = errors.New("the topic provided is not valid")
+	errBadOptions = errors.New("the channel options provided are not valid")
+)
+
+// topic represents a parsed emitter topic, of the form "key/channel/?options" or
+// "key/$share/group/channel/?options".
+type topic struct {
+	Key     string     // The key used to authorize the operation
+	Channel string     // The channel name, always terminated with a slash
+	Group   string     // The share group, if any
+	Options url.Values // The options provided in the query string
+}
+
+// parseTopic parses an emitter topic. When withKey is false, the topic is expected
+// to contain only the channel and the options (as in link and history requests).
+func parseTopic(text string, withKey bool) (*topic, error) {
+	t := new(topic)
+	if i := strings.IndexByte(text, '?'); i >= 0 {
+		opts, err := url.ParseQuery(text[i+1:])
+		if err != nil {
+			return nil, errBadOptions
+		}
+
+		t.Options = opts
+		text = text[:i]
+	}
+
+	parts := strings.FieldsFunc(text, func(c rune) bool { return c == '/' })
+	if withKey {
+		if len(parts) < 2 {
+			return nil, errBadTopic
+		}
+
+		t.Key, parts = parts[0], parts[1:]
+		if parts[0] == "$share" {
+			if len(parts) < 3 {
+				return nil, errBadTopic
+			}
+
+			t.Group, parts = parts[1], parts[2:]
+		}
+	}
+
+	// A trailing multi-level wildcard is equivalent to the prefix match that
+	// emitter performs by default.
+	if n := len(parts); n > 0 && parts[n-1] == "#" {
+		parts = parts[:n-1]
+	}
+
+	if len(parts) == 0 {
+		return nil, errBadTopic
+	}
+
+	for _, part := range parts {
+		if strings.ContainsAny(part, "#$") {
+			return nil, errBadTopic
+		}
+	}
+
+	t.Channel = strings.Join(parts, "/") + "/"
+	return t, nil
+}
+
+// Int returns an integer option, or the default value if the option is missing.
+func (t *topic) Int(name string, def int64) (int64, bool) {
+	v := t.Options.Get(name)
+	if v == "" {
+		return def, true
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	return n, err == nil
+}
+
+// Time returns a unix timestamp option, or the zero time if the option is missing.
+func (t *topic) Time(name string) (time.Time, bool) {
+	n, ok := t.Int(name, 0)
+	if !ok || n == 0 {
+		return time.Time{}, ok
+	}
+
+	return time.Unix(n, 0), true
+}
+
+// matches checks whether a channel matches a subscription pattern. Emitter matches
+// on prefixes and supports single-level wildcards.
+func matches(pattern, channel string) bool {
+	p := strings.Split(strings.TrimSuffix(pattern, "/"), "/")
+	c := strings.Split(strings.TrimSuffix(channel, "/"), "/")
+	if len(p) > len(c) {
+		return false
+	}
+
+	for i := range p {
+		if p[i] != "+" && p[i] != c[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package emitter
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// ChannelHandle is bound to a key and a channel, so that the operations on the channel
+// do not repeat them. Its default options are applied to every call, before the options
+// of the call.
+type ChannelHandle struct {
+	client  *Client  // The client to use
+	key     string   // The key of the channel
+	channel string   // The channel
+	options []Option // The default options
+}
+
+// Channel returns a handle bound to a key and a channel. The default options, such as
+// WithTTL, WithAtLeastOnce, WithRetain or WithoutEcho, are applied to every call where
+// they are meaningful.
+func (c *Client) Channel(key, channel string, options ...Option) *ChannelHandle {
+	return &ChannelHandle{
+		client:  c,
+		key:     key,
+		channel: channel,
+		options: options,
+	}
+}
+
+// Key returns the key the handle is bound to.
+func (h *ChannelHandle) Key() string {
+	return h.key
+}
+
+// Name returns the channel the handle is bound to.
+func (h *ChannelHandle) Name() string {
+	return h.channel
+}
+
+// Publish publishes a message to the channel.
+func (h *ChannelHandle) Publish(ctx context.Context, payload interface{}, options ...Option) error {
+	return h.client.PublishContext(ctx, h.key, h.channel, payload, h.with(options)...)
+}
+
+// Subscribe subscribes a handler to the channel and returns its handle.
+func (h *ChannelHandle) Subscribe(ctx context.Context, optionalHandler MessageHandler, options ...Option) (*Subscription, error) {
+	return h.client.SubscribeHandle(ctx, h.key, h.channel, optionalHandler, h.forSubscribe(options)...)
+}
+
+// SubscribeFrom subscribes a handler to the channel after replaying the messages stored
+// from a position.
+func (h *ChannelHandle) SubscribeFrom(ctx context.Context, from Position, handler MessageHandler, options ...Option) (*Subscription, error) {
+	return h.client.SubscribeFrom(ctx, h.key, h.channel, from, handler, h.forSubscribe(options)...)
+}
+
+// Consume starts a durable consumer of the messages stored in the channel.
+func (h *ChannelHandle) Consume(ctx context.Context, checkpoints Checkpointer, handler ContextHandler) (*Consumer, error) {
+	return h.client.Consume(ctx, h.key, h.channel, checkpoints, handler)
+}
+
+// SubscribeWithGroup subscribes a handler to the channel as part of a share group and
+// returns its handle.
+func (h *ChannelHandle) SubscribeWithGroup(ctx context.Context, shareGroup string, optionalHandler MessageHandler, options ...Option) (*Subscription, error) {
+	return h.client.SubscribeWithGroupHandle(ctx, h.key, h.channel, shareGroup, optionalHandler, h.forSubscribe(options)...)
+}
+
+// Unsubscribe removes every handler of the channel and unsubscribes from it.
+func (h *ChannelHandle) Unsubscribe(ctx context.Context) error {
+	return h.client.UnsubscribeContext(ctx, h.key, h.channel)
+}
+
+// Presence sends a presence request for the channel.
+func (h *ChannelHandle) Presence(ctx context.Context, status, changes bool) (*PresenceEvent, error) {
+	return h.client.PresenceContext(ctx, h.key, h.channel, status, changes)
+}
+
+// SubscribePresence subscribes a handler to the presence change events of the channel.
+func (h *ChannelHandle) SubscribePresence(ctx context.Context, handler PresenceHandler) (*PresenceSubscription, error) {
+	return h.client.SubscribePresenceContext(ctx, h.key, h.channel, handler)
+}
+
+// TrackPresence returns a tracker of the clients subscribed to the channel.
+func (h *ChannelHandle) TrackPresence(ctx context.Context) (*PresenceTracker, error) {
+	return h.client.TrackPresence(ctx, h.key, h.channel)
+}
+
+// History returns an iterator over the messages stored in the channel between two unix
+// timestamps, up to the limit provided.
+func (h *ChannelHandle) History(ctx context.Context, from, until int64, limit int) func(func(m HistoryMessage, err error) bool) {
+	return h.client.HistoryContext(ctx, h.key, h.channel, from, until, limit)
+}
+
+// HistoryQuery starts building a query of the messages stored in the channel.
+func (h *ChannelHandle) HistoryQuery() HistoryQuery {
+	return h.client.HistoryQuery(h.key, h.channel)
+}
+
+// GenerateKey generates a key for the channel, the key of the handle being used as the
+// secret key.
+func (h *ChannelHandle) GenerateKey(ctx context.Context, permissions Permission, ttl time.Duration) (*GeneratedKey, error) {
+	return h.client.GenerateChannelKey(ctx, h.key, h.channel, permissions, ttl)
+}
+
+// CreateLink creates a link to the channel, which carries the default options.
+func (h *ChannelHandle) CreateLink(ctx context.Context, name string, optionalHandler MessageHandler, options ...Option) (*Link, error) {
+	return h.client.CreateLinkContext(ctx, h.key, h.channel, name, optionalHandler, h.with(options)...)
+}
+
+// with returns the default options followed by the ones provided.
+func (h *ChannelHandle) with(options []Option) []Option {
+	return append(append([]Option{}, h.options...), options...)
+}
+
+// forSubscribe returns the options of a subscribe, without the default options which
+// only apply to publishes.
+func (h *ChannelHandle) forSubscribe(options []Option) []Option {
+	return subscribeOptions(h.options, options)
+}
+
+// subscribeOptions returns the default options followed by the ones provided, without
+// the default options which only apply to publishes.
+func subscribeOptions(defaults, options []Option) []Option {
+	out := make([]Option, 0, len(defaults)+len(options))
+	for _, o := range defaults {
+		switch {
+		case o == withRetain, o == withQos0, o == withQos1:
+		case strings.HasPrefix(o.String(), "ttl="):
+		default:
+			out = append(out, o)
+		}
+	}
+	return append(out, options...)
+}
@@ -0,0 +1,58 @@
+package emitter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelHandle(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	ctx := context.Background()
+	room := c.Channel("key", "sensors/room1/", WithTTL(60), WithAtLeastOnce())
+	assert.Equal(t, "key", room.Key())
+	assert.Equal(t, "sensors/room1/", room.Name())
+
+	received := make(chan string, 1)
+	sub, err := room.Subscribe(ctx, func(_ *Client, m Message) { received <- string(m.Payload()) })
+	assert.NoError(t, err)
+	assert.Equal(t, []Option{}, c.subs.All()[0].Options)
+
+	assert.NoError(t, room.Publish(ctx, "21.5"))
+	assert.Equal(t, "21.5", receive(t, received))
+
+	presence, err := room.Presence(ctx, true, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "sensors/room1/", presence.Channel)
+
+	// The message was stored thanks to the default TTL
+	var history []string
+	for m, err := range room.History(ctx, time.Now().Add(-time.Minute).Unix(), time.Now().Add(time.Minute).Unix(), 10) {
+		assert.NoError(t, err)
+		history = append(history, string(m.Payload))
+	}
+	assert.Equal(t, []string{"21.5"}, history)
+
+	key, err := room.GenerateKey(ctx, PermRead|PermWrite, 0)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, key.Key)
+	assert.Equal(t, "sensors/room1/", key.Channel)
+
+	assert.NoError(t, sub.Unsubscribe())
+	assert.NoError(t, room.Unsubscribe(ctx))
+}
+
+func TestChannelHandleOptions(t *testing.T) {
+	h := NewClient().Channel("key", "a/", WithTTL(10), WithRetain(), WithoutEcho())
+	assert.Equal(t, []Option{WithTTL(10), WithRetain(), WithoutEcho(), WithLast(1)}, h.with([]Option{WithLast(1)}))
+	assert.Equal(t, []Option{WithoutEcho(), WithLast(1)}, h.forSubscribe([]Option{WithLast(1)}))
+}
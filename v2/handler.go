@@ -0,0 +1,126 @@
+package emitter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Handle converts a handler which returns an error into a message handler. The context
+// passed to the handler expires after the default timeout of the client. The errors
+// returned are reported to the failure handler and counted, and the message is sent to
+// the dead-letter channel if one is configured.
+func Handle(handler ContextHandler) MessageHandler {
+	return func(c *Client, m Message) {
+		ctx, cancel := c.withTimeout(context.Background())
+		defer cancel()
+
+		if err := handler(ctx, c, m); err != nil {
+			c.fail(&HandlerError{
+				Topic:   m.Topic(),
+				Payload: m.Payload(),
+				Err:     err,
+			})
+		}
+	}
+}
+
+// OnHandlerFailure sets the function that will be called when a message or presence
+// handler returns an error or panics. By default, the failures are logged.
+func (c *Client) OnHandlerFailure(handler FailureHandler) {
+	c.failure = handler
+}
+
+// invoke calls a message handler, recovering from a panic.
+func (c *Client) invoke(h MessageHandler, m Message) {
+	defer c.recoverHandler(m.Topic(), m.Payload())
+	c.wrapHandler(h)(c, m)
+}
+
+// invokePresence calls the presence handler, recovering from a panic.
+func (c *Client) invokePresence(ev PresenceEvent, m Message) {
+	defer c.recoverHandler(m.Topic(), m.Payload())
+	c.presence(c, ev)
+}
+
+// recoverHandler reports the panic of a handler, if any. It must be deferred.
+func (c *Client) recoverHandler(topic string, payload []byte) {
+	if r := recover(); r != nil {
+		c.fail(&HandlerError{
+			Topic:   topic,
+			Payload: payload,
+			Err:     fmt.Errorf("panic: %v", r),
+			Panic:   r,
+			Stack:   debug.Stack(),
+		})
+	}
+}
+
+// fail reports the failure of a handler.
+func (c *Client) fail(e *HandlerError) {
+	c.metrics.Add(MetricHandlerFailures, 1, Label{"prefix", channelPrefix(e.Topic)})
+	if c.deadLetter != nil && !c.deadLetter.carries(e.Topic) {
+		go c.sendDeadLetter(e)
+	}
+
+	if c.failure != nil {
+		c.failure(c, e)
+		return
+	}
+
+	attrs := []any{slog.String("topic", e.Topic), slog.Any("error", e.Err)}
+	if e.Stack != nil {
+		attrs = append(attrs, slog.String("stack", string(e.Stack)))
+	}
+	c.logger.Error("emitter: message handler failed", attrs...)
+}
+
+// ------------------------------------------------------------------------------------
+
+// deadLetter represents the channel which receives the messages that could not be
+// handled.
+type deadLetter struct {
+	Key     string // The key used to publish
+	Channel string // The channel to publish to
+}
+
+// carries returns whether a topic is the dead-letter channel. The failures of its own
+// handlers are not sent back to it, which would repeat them endlessly.
+func (d *deadLetter) carries(topic string) bool {
+	channel, _, _ := strings.Cut(d.Channel, "?")
+	return NewChannel(topic).Name() == NewChannel(channel).Name()
+}
+
+// deadLetterMessage represents a message which could not be handled, as published to
+// the dead-letter channel.
+type deadLetterMessage struct {
+	Topic   string `json:"topic"`
+	Payload []byte `json:"payload"`
+	Error   string `json:"error"`
+	Time    int64  `json:"time"`
+}
+
+// sendDeadLetter publishes a message which could not be handled to the dead-letter
+// channel. It runs on its own goroutine, since waiting for the acknowledgement from a
+// handler would block the network goroutine.
+func (c *Client) sendDeadLetter(e *HandlerError) {
+	b, err := json.Marshal(&deadLetterMessage{
+		Topic:   e.Topic,
+		Payload: e.Payload,
+		Error:   e.Err.Error(),
+		Time:    time.Now().Unix(),
+	})
+	if err == nil {
+		err = c.Publish(c.deadLetter.Key, c.deadLetter.Channel, b, WithAtLeastOnce())
+	}
+
+	if err != nil {
+		c.logger.Error("emitter: unable to publish to the dead-letter channel",
+			slog.String("topic", e.Topic),
+			slog.Any("error", err))
+	}
+}
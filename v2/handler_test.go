@@ -0,0 +1,123 @@
+package emitter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerPanic(t *testing.T) {
+	c := NewClient()
+
+	var failures []*HandlerError
+	c.OnHandlerFailure(func(_ *Client, e *HandlerError) {
+		failures = append(failures, e)
+	})
+
+	c.OnMessage(func(*Client, Message) { panic("boom") })
+	c.OnPresence(func(*Client, PresenceEvent) { panic("presence") })
+	c.handlers.AddHandler("a/", func(*Client, Message) { panic("a") })
+
+	assert.NotPanics(t, func() {
+		c.onMessage(nil, &message{topic: "a/", payload: "hello"})
+		c.onMessage(nil, &message{topic: "b/", payload: "hi"})
+		c.onMessage(nil, &message{topic: "emitter/presence/", payload: `{"event":"subscribe","channel":"a/","who":{"id":"x"}}`})
+	})
+
+	assert.Len(t, failures, 3)
+	assert.Equal(t, "a/", failures[0].Topic)
+	assert.Equal(t, "a", failures[0].Panic)
+	assert.Contains(t, string(failures[0].Stack), "handler_test.go")
+	assert.Equal(t, []byte("hi"), failures[1].Payload)
+	assert.Equal(t, "emitter: unable to handle the message on 'b/', due to panic: boom", failures[1].Error())
+	assert.Equal(t, "presence", failures[2].Panic)
+}
+
+func TestHandleError(t *testing.T) {
+	m := NewOpenMetrics()
+	c := NewClient(WithMetrics(m))
+
+	errInvalid := errors.New("invalid")
+	var failure *HandlerError
+	c.OnHandlerFailure(func(_ *Client, e *HandlerError) { failure = e })
+	c.handlers.AddHandler("a/", Handle(func(ctx context.Context, _ *Client, msg Message) error {
+		_, ok := ctx.Deadline()
+		assert.True(t, ok)
+		if string(msg.Payload()) == "bad" {
+			return errInvalid
+		}
+		return nil
+	}))
+
+	c.onMessage(nil, &message{topic: "a/", payload: "good"})
+	assert.Nil(t, failure)
+
+	c.onMessage(nil, &message{topic: "a/", payload: "bad"})
+	assert.ErrorIs(t, failure, errInvalid)
+	assert.Nil(t, failure.Stack)
+
+	var buffer bytes.Buffer
+	m.WriteTo(&buffer)
+	assert.Contains(t, buffer.String(), `emitter_handler_failures_total{prefix="a"} 1`)
+}
+
+func TestDeadLetter(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c := NewClient(WithBrokers(srv.URL), WithDeadLetter("key", "dead/"))
+	c.OnHandlerFailure(func(*Client, *HandlerError) {})
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+
+	letters := make(chan deadLetterMessage, 1)
+	assert.NoError(t, c.Subscribe("key", "dead/", func(_ *Client, m Message) {
+		var letter deadLetterMessage
+		assert.NoError(t, json.Unmarshal(m.Payload(), &letter))
+		letters <- letter
+	}))
+
+	assert.NoError(t, c.Subscribe("key", "a/", Handle(func(context.Context, *Client, Message) error {
+		return errors.New("invalid")
+	})))
+	assert.NoError(t, c.Publish("key", "a/", "hello"))
+
+	select {
+	case letter := <-letters:
+		assert.Equal(t, "a/", letter.Topic)
+		assert.Equal(t, []byte("hello"), letter.Payload)
+		assert.Equal(t, "invalid", letter.Error)
+	case <-time.After(time.Second):
+		assert.Fail(t, "the dead letter was not received")
+	}
+}
+
+func TestDeadLetterFailure(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	var failures atomic.Int32
+	c := NewClient(WithBrokers(srv.URL), WithDeadLetter("key", "dead/"))
+	c.OnHandlerFailure(func(*Client, *HandlerError) { failures.Add(1) })
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+
+	// The failures of the dead-letter handler are not dead-lettered again
+	failing := Handle(func(context.Context, *Client, Message) error {
+		return errors.New("invalid")
+	})
+	assert.NoError(t, c.Subscribe("key", "dead/", failing))
+	assert.NoError(t, c.Subscribe("key", "a/", failing))
+	assert.NoError(t, c.Publish("key", "a/", "hello"))
+
+	assert.Eventually(t, func() bool { return failures.Load() == 2 }, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(2), failures.Load())
+}
@@ -0,0 +1,312 @@
+package emitter
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"iter"
+	"time"
+)
+
+// The number of messages requested at once when no page size is set.
+const defaultPageSize = 100
+
+// HistoryOrder represents the order in which the stored messages are iterated.
+type HistoryOrder uint8
+
+// Various history orders
+const (
+	NewestFirst HistoryOrder = iota // From the most recent message to the oldest one
+	OldestFirst                     // From the oldest message to the most recent one
+)
+
+// HistoryCursor is an opaque token which designates a stored message, so that a query
+// can be resumed after it. It can be persisted as a string.
+type HistoryCursor string
+
+// Cursor returns the token designating the message, to resume a query after it.
+func (m HistoryMessage) Cursor() HistoryCursor {
+	return HistoryCursor(base64.RawURLEncoding.EncodeToString(m.ID))
+}
+
+// id decodes the message identifier of the cursor.
+func (c HistoryCursor) id() (MessageID, error) {
+	id, err := base64.RawURLEncoding.DecodeString(string(c))
+	if err != nil || len(id) == 0 {
+		return nil, fmt.Errorf("%w, '%s' is not a history cursor", ErrInvalidCursor, string(c))
+	}
+	return id, nil
+}
+
+// ------------------------------------------------------------------------------------
+
+// HistoryQuery represents a query of the messages stored in a channel. It is built by
+// chaining its methods, each of which returns a modified copy.
+type HistoryQuery struct {
+	client   *Client       // The client to query with
+	key      string        // The key of the channel
+	channel  string        // The channel to query
+	from     time.Time     // The time of the oldest message, if any
+	until    time.Time     // The time of the most recent message, if any
+	limit    int           // The maximum number of messages, unlimited if zero
+	pageSize int           // The number of messages requested at once
+	order    HistoryOrder  // The order of the iteration
+	after    HistoryCursor // The message to resume after, if any
+	before   MessageID     // The message to stop before, if any, oldest first only
+}
+
+// HistoryQuery starts building a query of the messages stored in a channel, which yields
+// every message stored, the most recent first, unless restricted.
+func (c *Client) HistoryQuery(key, channel string) HistoryQuery {
+	return HistoryQuery{
+		client:   c,
+		key:      key,
+		channel:  channel,
+		pageSize: defaultPageSize,
+	}
+}
+
+// From returns a copy of the query restricted to the messages stored at or after a
+// point in time. The bounds are whole seconds, as the broker only honours those, so
+// the time is rounded down and the messages stored earlier within that second are
+// included.
+func (q HistoryQuery) From(from time.Time) HistoryQuery {
+	q.from = from
+	return q
+}
+
+// Until returns a copy of the query restricted to the messages stored at or before a
+// point in time. The bounds are whole seconds, as the broker only honours those, so
+// the time is rounded up and the messages stored later within that second are
+// included.
+func (q HistoryQuery) Until(until time.Time) HistoryQuery {
+	q.until = until
+	return q
+}
+
+// Limit returns a copy of the query which yields at most n messages, or every message
+// if n is zero. They are the first n messages in the order of the query.
+func (q HistoryQuery) Limit(n int) HistoryQuery {
+	q.limit = n
+	return q
+}
+
+// PageSize returns a copy of the query which requests n messages at once, as the size
+// of a response is limited.
+func (q HistoryQuery) PageSize(n int) HistoryQuery {
+	q.pageSize = n
+	return q
+}
+
+// Order returns a copy of the query which yields the messages in the order provided.
+func (q HistoryQuery) Order(order HistoryOrder) HistoryQuery {
+	q.order = order
+	return q
+}
+
+// After returns a copy of the query which resumes after the message designated by the
+// cursor, in the order of the query.
+func (q HistoryQuery) After(cursor HistoryCursor) HistoryQuery {
+	q.after = cursor
+	return q
+}
+
+// stopBefore returns a copy of the query which, oldest first, stops before the message
+// with an identifier.
+func (q HistoryQuery) stopBefore(id MessageID) HistoryQuery {
+	q.before = id
+	return q
+}
+
+// Messages returns an iterator over the messages matching the query. Each page request
+// honours the deadline and cancellation of the context, and the iteration stops after
+// the first error. The broker stores the messages from the most recent one, so oldest
+// first, the pages are walked back before the first message is yielded, then requested
+// again in order.
+func (q HistoryQuery) Messages(ctx context.Context) iter.Seq2[HistoryMessage, error] {
+	return func(yield func(HistoryMessage, error) bool) {
+		if err := q.validate(); err != nil {
+			yield(HistoryMessage{}, err)
+			return
+		}
+
+		var after MessageID
+		if q.after != "" {
+			id, err := q.after.id()
+			if err != nil {
+				yield(HistoryMessage{}, err)
+				return
+			}
+			after = id
+		}
+
+		key, err := q.client.resolveKey(ctx, q.key, q.channel, PermLoad)
+		if err != nil {
+			yield(HistoryMessage{}, err)
+			return
+		}
+
+		if q.order == OldestFirst {
+			q.oldestFirst(ctx, key, after, yield)
+			return
+		}
+		q.newestFirst(ctx, key, after, yield)
+	}
+}
+
+// newestFirst yields the messages from the most recent one, one page at a time.
+func (q HistoryQuery) newestFirst(ctx context.Context, key string, before MessageID, yield func(HistoryMessage, error) bool) {
+	for yielded := 0; q.limit == 0 || yielded < q.limit; {
+		size := q.pageSize
+		if q.limit > 0 && q.limit-yielded < size {
+			size = q.limit - yielded
+		}
+
+		page, err := q.page(ctx, key, before, size)
+		if err != nil {
+			yield(HistoryMessage{}, err)
+			return
+		}
+
+		if len(page) == 0 {
+			return
+		}
+
+		// Pages are in publication order and must be iterated backwards
+		for i := len(page) - 1; i >= 0 && (q.limit == 0 || yielded < q.limit); i-- {
+			if !yield(page[i], nil) {
+				return
+			}
+			yielded++
+		}
+
+		before = page[0].ID
+	}
+}
+
+// oldestFirst walks the pages back from the most recent message down to the cursor, if
+// any, only keeping the identifier each older page is requested before. Then, it
+// requests these pages again from the oldest one and yields their messages, so that at
+// most two pages are held in memory. The most recent page is kept from the first walk,
+// since more messages may have been stored in the meantime. The identifiers are only
+// compared for equality, as their order is up to the broker.
+func (q HistoryQuery) oldestFirst(ctx context.Context, key string, after MessageID, yield func(HistoryMessage, error) bool) {
+	newest, err := q.page(ctx, key, q.before, q.pageSize)
+	if err != nil {
+		yield(HistoryMessage{}, err)
+		return
+	}
+
+	var bounds []MessageID // From the most recent page
+	for page := newest; len(page) > 0 && indexOf(page, after) < 0; {
+		before := page[0].ID
+		if page, err = q.page(ctx, key, before, q.pageSize); err != nil {
+			yield(HistoryMessage{}, err)
+			return
+		}
+
+		if len(page) > 0 {
+			bounds = append(bounds, before)
+		}
+	}
+
+	// Skip the messages up to the cursor, and those yielded already which appear again
+	// in a page when older messages expired in the meantime
+	var last MessageID
+	yielded := 0
+	emit := func(page []HistoryMessage) bool {
+		page = page[indexOf(page, after)+1:]
+		for _, m := range page[indexOf(page, last)+1:] {
+			if q.limit > 0 && yielded == q.limit {
+				return false
+			}
+
+			if !yield(m, nil) {
+				return false
+			}
+			last = m.ID
+			yielded++
+		}
+		return true
+	}
+
+	for i := len(bounds) - 1; i >= 0; i-- {
+		page, err := q.page(ctx, key, bounds[i], q.pageSize)
+		if err != nil {
+			yield(HistoryMessage{}, err)
+			return
+		}
+
+		if !emit(page) {
+			return
+		}
+	}
+	emit(newest)
+}
+
+// indexOf returns the index of the message with an identifier in a page, or -1 if the
+// page does not contain it.
+func indexOf(page []HistoryMessage, id MessageID) int {
+	if id == nil {
+		return -1
+	}
+
+	for i := range page {
+		if bytes.Equal(page[i].ID, id) {
+			return i
+		}
+	}
+	return -1
+}
+
+// page requests the messages stored before a message, or the most recent ones, in
+// publication order.
+func (q HistoryQuery) page(ctx context.Context, key string, before MessageID, size int) ([]HistoryMessage, error) {
+	resp, err := q.client.request(ctx, "history", &historyRequest{
+		Channel:     q.topic(key, size),
+		StartFromID: before,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result, ok := resp.(*historyResponse)
+	if !ok {
+		return nil, ErrUnmarshal
+	}
+	return result.Messages, nil
+}
+
+// topic formats the topic of a page request, which only carries the bounds of the time
+// range that are set.
+func (q HistoryQuery) topic(key string, size int) string {
+	options := []Option{WithLast(size)}
+	if !q.from.IsZero() {
+		options = append(options, WithFrom(q.from))
+	}
+
+	if !q.until.IsZero() {
+		until := q.until.Unix()
+		if q.until.Nanosecond() > 0 {
+			until++
+		}
+		options = append(options, WithUntil(time.Unix(until, 0)))
+	}
+
+	return NewChannel(q.channel).WithKey(key).WithOptions(options...).String()
+}
+
+// validate checks the channel and the bounds of the query.
+func (q HistoryQuery) validate() error {
+	switch {
+	case q.limit < 0:
+		return fmt.Errorf("emitter: the limit of a history query cannot be negative, got %d", q.limit)
+	case q.pageSize <= 0:
+		return fmt.Errorf("emitter: the page size of a history query must be positive, got %d", q.pageSize)
+	case !q.from.IsZero() && !q.until.IsZero() && q.until.Before(q.from):
+		return fmt.Errorf("emitter: the history query ends before it starts")
+	default:
+		return NewChannel(q.channel).Validate()
+	}
+}
@@ -0,0 +1,86 @@
+package emitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryQuery(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, c.Publish("key", "query/", fmt.Sprintf("%d", i), WithTTL(60)))
+	}
+
+	ctx := context.Background()
+	collect := func(q HistoryQuery) (payloads []string, cursors []HistoryCursor) {
+		for m, err := range q.Messages(ctx) {
+			assert.NoError(t, err)
+			payloads = append(payloads, string(m.Payload))
+			cursors = append(cursors, m.Cursor())
+		}
+		return
+	}
+
+	q := c.HistoryQuery("key", "query/").PageSize(2)
+	all, cursors := collect(q)
+	assert.Equal(t, []string{"5", "4", "3", "2", "1"}, all)
+
+	latest, _ := collect(q.Limit(3))
+	assert.Equal(t, []string{"5", "4", "3"}, latest)
+
+	oldest, _ := collect(q.Order(OldestFirst).Limit(2))
+	assert.Equal(t, []string{"1", "2"}, oldest)
+
+	// Resume after the message "2", in both orders
+	resumed, _ := collect(q.Order(OldestFirst).After(cursors[3]))
+	assert.Equal(t, []string{"3", "4", "5"}, resumed)
+	resumed, _ = collect(q.After(cursors[3]))
+	assert.Equal(t, []string{"1"}, resumed)
+
+	// The range is open by default
+	none, _ := collect(q.Until(time.Now().Add(-time.Minute)))
+	assert.Empty(t, none)
+	some, _ := collect(q.From(time.Now().Add(-time.Minute)).Limit(1))
+	assert.Equal(t, []string{"5"}, some)
+}
+
+func TestHistoryQueryErrors(t *testing.T) {
+	q := NewClient().HistoryQuery("key", "query/")
+	for _, invalid := range []HistoryQuery{
+		q.Limit(-1),
+		q.PageSize(0),
+		q.From(time.Now()).Until(time.Now().Add(-time.Hour)),
+		q.After("!"),
+	} {
+		var errs []error
+		for _, err := range invalid.Messages(context.Background()) {
+			errs = append(errs, err)
+		}
+		assert.Len(t, errs, 1)
+	}
+
+	for _, err := range q.After("not a cursor!").Messages(context.Background()) {
+		assert.True(t, errors.Is(err, ErrInvalidCursor))
+	}
+}
+
+func TestHistoryQueryTopic(t *testing.T) {
+	q := NewClient().HistoryQuery("key", "a/b")
+	assert.Equal(t, "key/a/b/?last=10", q.topic("key", 10))
+
+	from := time.Unix(1589626821, 500)
+	until := time.Unix(1589626830, 1)
+	assert.Equal(t, "key/a/b/?last=1&from=1589626821&until=1589626831", q.From(from).Until(until).topic("key", 1))
+}
@@ -0,0 +1,138 @@
+package emitter
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The permissions required to create a link, which can both publish and subscribe.
+const linkPermissions = PermRead | PermWrite
+
+// KeyProvider provides the channel keys, which the client consults whenever an operation
+// is made with an empty key.
+type KeyProvider interface {
+
+	// Key returns a key granting the permissions on the channel, such as PermRead to
+	// subscribe, PermWrite to publish, PermPresence for presence or PermLoad to load the
+	// history. A publish with a TTL or retained also requires PermStore, and a subscribe
+	// retrieving the last messages also requires PermLoad.
+	Key(ctx context.Context, channel string, permissions Permission) (string, error)
+}
+
+// KeyGenerator is a key provider which generates the channel keys on demand from a
+// secret key, and caches them until shortly before they expire.
+type KeyGenerator struct {
+	sync.Mutex
+	client  *Client               // The client used to generate the keys
+	secret  string                // The secret key
+	ttl     time.Duration         // The time-to-live of the keys, unlimited if zero
+	Pattern func(string) string   // Maps a channel to the channel pattern to generate a key for
+	keys    map[string]*cachedKey // The cached keys, by pattern and permissions
+}
+
+// cachedKey represents a generated key.
+type cachedKey struct {
+	key        string    // The generated key
+	refreshAt  time.Time // The time after which the key is refreshed, zero if never
+	expires    time.Time // The time the key expires, zero if never
+	refreshing bool      // Whether the key is being refreshed
+}
+
+// NewKeyGenerator creates a key provider which generates the channel keys with the secret
+// key provided. The keys expire after the TTL, rounded up to the second, and are refreshed
+// once four fifths of it elapsed. By default, a key is generated for each channel; set
+// Pattern to share keys, for example across the sub-channels of a channel.
+func NewKeyGenerator(c *Client, secret string, ttl time.Duration) *KeyGenerator {
+	return &KeyGenerator{
+		client:  c,
+		secret:  secret,
+		ttl:     roundTTL(ttl),
+		Pattern: func(channel string) string { return channel },
+		keys:    make(map[string]*cachedKey),
+	}
+}
+
+// Key returns a cached key for the channel pattern and the permissions, or generates one.
+func (g *KeyGenerator) Key(ctx context.Context, channel string, permissions Permission) (string, error) {
+	pattern := g.Pattern(channel)
+	id := pattern + "#" + permissions.String()
+	now := time.Now()
+
+	g.Lock()
+	e, ok := g.keys[id]
+	switch {
+	case !ok || (!e.expires.IsZero() && !now.Before(e.expires)):
+		g.Unlock()
+		return g.generate(ctx, id, pattern, permissions)
+	case !e.refreshAt.IsZero() && !now.Before(e.refreshAt) && !e.refreshing:
+		e.refreshing = true
+		go g.refresh(id, pattern, permissions)
+	}
+
+	g.Unlock()
+	return e.key, nil
+}
+
+// generate generates a key and caches it.
+func (g *KeyGenerator) generate(ctx context.Context, id, pattern string, permissions Permission) (string, error) {
+	now := time.Now()
+	key, err := g.client.GenerateChannelKey(ctx, g.secret, pattern, permissions, g.ttl)
+	if err != nil {
+		return "", err
+	}
+
+	e := &cachedKey{key: key.Key, expires: key.Expires}
+	if g.ttl > 0 {
+		e.refreshAt = now.Add(g.ttl * 4 / 5)
+	}
+
+	g.Lock()
+	g.keys[id] = e
+	g.Unlock()
+	return key.Key, nil
+}
+
+// refresh generates a key ahead of the expiry of the cached one.
+func (g *KeyGenerator) refresh(id, pattern string, permissions Permission) {
+	ctx, cancel := g.client.withTimeout(context.Background())
+	defer cancel()
+
+	if _, err := g.generate(ctx, id, pattern, permissions); err != nil {
+		g.Lock()
+		if e, ok := g.keys[id]; ok {
+			e.refreshing = false
+		}
+		g.Unlock()
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+// resolveKey returns the key provided or, if empty, the key of the key provider.
+func (c *Client) resolveKey(ctx context.Context, key, channel string, permissions Permission) (string, error) {
+	if key != "" || c.keys == nil {
+		return key, nil
+	}
+
+	return c.keys.Key(ctx, channel, permissions)
+}
+
+// permissionsFor returns the permissions required by an operation along with its
+// options: a publish with a TTL or retained also requires PermStore, and a subscribe
+// retrieving the last messages also requires PermLoad.
+func permissionsFor(permissions Permission, options []Option) Permission {
+	for _, o := range options {
+		name, value, _ := strings.Cut(o.String(), "=")
+		switch {
+		case o == withRetain:
+			permissions |= PermStore
+		case name == "ttl" && value != "0":
+			permissions |= PermStore
+		case name == "last" && value != "0":
+			permissions |= PermLoad
+		}
+	}
+	return permissions
+}
@@ -0,0 +1,143 @@
+package emitter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyGenerator(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c := NewClient(WithBrokers(srv.URL), WithKeyGenerator("secret", time.Hour))
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+
+	// The operations made with an empty key use generated keys
+	received := make(chan string, 1)
+	assert.NoError(t, c.Subscribe("", "a/", func(_ *Client, m Message) { received <- string(m.Payload()) }))
+	assert.NoError(t, c.Publish("", "a/", "hello"))
+	assert.Equal(t, "hello", receive(t, received))
+
+	_, err := c.Presence("", "a/", true, false)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), c.RequestStats().Completed)
+
+	// The keys are cached by channel and permissions
+	assert.NoError(t, c.Publish("", "a/", "hello"))
+	assert.Equal(t, "hello", receive(t, received))
+	assert.Equal(t, uint64(4), c.RequestStats().Completed)
+	assert.NoError(t, c.Unsubscribe("", "a/"))
+}
+
+func TestKeyGeneratorPattern(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c := NewClient(WithBrokers(srv.URL))
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+
+	ctx := context.Background()
+	g := NewKeyGenerator(c, "secret", 0)
+	g.Pattern = func(channel string) string { return channelPrefix(channel) + "/#/" }
+
+	k1, err := g.Key(ctx, "a/b/", PermWrite)
+	assert.NoError(t, err)
+	k2, err := g.Key(ctx, "a/c/", PermWrite)
+	assert.NoError(t, err)
+	k3, err := g.Key(ctx, "a/c/", PermRead)
+	assert.NoError(t, err)
+	assert.Equal(t, k1, k2)
+	assert.NotEqual(t, k1, k3)
+
+	// The key covers the sub-channels of the pattern
+	assert.NoError(t, c.Publish(k1, "a/d/", "hello"))
+}
+
+func TestKeyGeneratorRefresh(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c := NewClient(WithBrokers(srv.URL))
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+
+	ctx := context.Background()
+	g := NewKeyGenerator(c, "secret", time.Second)
+	k1, err := g.Key(ctx, "a/", PermRead)
+	assert.NoError(t, err)
+
+	// Once four fifths of the TTL elapsed, the key is refreshed in the background
+	time.Sleep(850 * time.Millisecond)
+	k2, err := g.Key(ctx, "a/", PermRead)
+	assert.NoError(t, err)
+	assert.Equal(t, k1, k2)
+	assert.Eventually(t, func() bool {
+		k3, err := g.Key(ctx, "a/", PermRead)
+		return err == nil && k3 != k1
+	}, time.Second, 10*time.Millisecond)
+}
+
+// recordingProvider records the permissions requested from a key provider.
+type recordingProvider struct {
+	sync.Mutex
+	KeyProvider
+	requested []Permission
+}
+
+func (p *recordingProvider) Key(ctx context.Context, channel string, permissions Permission) (string, error) {
+	p.Lock()
+	p.requested = append(p.requested, permissions)
+	p.Unlock()
+	return p.KeyProvider.Key(ctx, channel, permissions)
+}
+
+func (p *recordingProvider) last() Permission {
+	p.Lock()
+	defer p.Unlock()
+	return p.requested[len(p.requested)-1]
+}
+
+func TestKeyPermissions(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	provider := new(recordingProvider)
+	c := NewClient(WithBrokers(srv.URL), WithKeyProvider(provider))
+	provider.KeyProvider = NewKeyGenerator(c, "secret", time.Hour)
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+
+	// The options which store or load messages require the matching permissions
+	assert.NoError(t, c.Publish("", "a/", "live"))
+	assert.Equal(t, PermWrite, provider.last())
+	assert.NoError(t, c.Publish("", "a/", "stored", WithTTL(60)))
+	assert.Equal(t, PermWrite|PermStore, provider.last())
+	assert.NoError(t, c.Publish("", "a/", "retained", WithRetain()))
+	assert.Equal(t, PermWrite|PermStore, provider.last())
+
+	received := make(chan string, 10)
+	assert.NoError(t, c.Subscribe("", "a/", func(_ *Client, m Message) { received <- string(m.Payload()) }, WithLast(1)))
+	assert.Equal(t, PermRead|PermLoad, provider.last())
+	assert.Equal(t, "retained", receive(t, received))
+
+	var stored []string
+	for m, err := range c.HistoryQuery("", "a/").Messages(context.Background()) {
+		assert.NoError(t, err)
+		stored = append(stored, string(m.Payload))
+	}
+	assert.Equal(t, []string{"retained", "stored"}, stored)
+}
+
+func TestPermissionsFor(t *testing.T) {
+	assert.Equal(t, PermWrite, permissionsFor(PermWrite, []Option{WithAtLeastOnce(), WithTTL(0)}))
+	assert.Equal(t, PermWrite|PermStore, permissionsFor(PermWrite, []Option{WithTTL(10)}))
+	assert.Equal(t, PermRead, permissionsFor(PermRead, []Option{WithoutEcho(), WithLast(0)}))
+	assert.Equal(t, PermRead|PermLoad, permissionsFor(PermRead, []Option{WithLast(5)}))
+}
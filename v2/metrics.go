@@ -0,0 +1,332 @@
+package emitter
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Various metrics recorded by the client
+const (
+	MetricMessagesPublished = "emitter_messages_published"       // Counter of the messages published, by channel prefix
+	MetricMessagesReceived  = "emitter_messages_received"        // Counter of the messages received, by channel prefix
+	MetricMessagesDropped   = "emitter_messages_dropped"         // Counter of the messages dropped by the dispatcher, by channel prefix
+	MetricBytesSent         = "emitter_bytes_sent"               // Counter of the payload bytes sent
+	MetricBytesReceived     = "emitter_bytes_received"           // Counter of the payload bytes received
+	MetricRequestDuration   = "emitter_request_duration_seconds" // Histogram of the request latency, by operation
+	MetricHandlerDuration   = "emitter_handler_duration_seconds" // Histogram of the handler execution time, by channel prefix
+	MetricHandlerFailures   = "emitter_handler_failures"         // Counter of the handlers which failed or panicked, by channel prefix
+	MetricTimeouts          = "emitter_timeouts"                 // Counter of the operations which timed out, by operation
+	MetricReconnects        = "emitter_reconnects"               // Counter of the reconnections to the broker
+	MetricSubscriptions     = "emitter_subscriptions"            // Gauge of the active subscriptions
+)
+
+// metricHelp describes the metrics recorded by the client.
+var metricHelp = map[string]string{
+	MetricMessagesPublished: "The number of messages published, by channel prefix.",
+	MetricMessagesReceived:  "The number of messages received, by channel prefix.",
+	MetricMessagesDropped:   "The number of messages dropped because a dispatch queue was full, by channel prefix.",
+	MetricBytesSent:         "The number of payload bytes sent to the broker.",
+	MetricBytesReceived:     "The number of payload bytes received from the broker.",
+	MetricRequestDuration:   "The latency of the requests made to the broker, by operation.",
+	MetricHandlerDuration:   "The execution time of the message handlers, by channel prefix.",
+	MetricHandlerFailures:   "The number of messages which a handler failed to process, by channel prefix.",
+	MetricTimeouts:          "The number of operations which timed out, by operation.",
+	MetricReconnects:        "The number of reconnections to the broker.",
+	MetricSubscriptions:     "The number of active subscriptions.",
+}
+
+// Label represents a dimension of a metric, such as the operation of a request.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Metrics receives the measurements made by the client. The implementations must be
+// safe for concurrent use.
+type Metrics interface {
+
+	// Add increments a counter by the delta provided.
+	Add(name string, delta float64, labels ...Label)
+
+	// Set sets the value of a gauge.
+	Set(name string, value float64, labels ...Label)
+
+	// Observe records a value, such as a duration in seconds, in a histogram.
+	Observe(name string, value float64, labels ...Label)
+}
+
+// nopMetrics discards the measurements.
+type nopMetrics struct{}
+
+func (nopMetrics) Add(string, float64, ...Label)     {}
+func (nopMetrics) Set(string, float64, ...Label)     {}
+func (nopMetrics) Observe(string, float64, ...Label) {}
+
+// formatLabels formats a set of labels in the OpenMetrics text format, with the extra
+// labels appended.
+func formatLabels(labels []Label, extra ...Label) string {
+	all := append(append([]Label{}, labels...), extra...)
+	if len(all) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, l := range all {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+
+		sb.WriteString(l.Name)
+		sb.WriteString(`="`)
+		sb.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(l.Value))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// channelPrefix returns the first segment of a channel or a topic, which is used to
+// label the metrics without creating a series per channel.
+func channelPrefix(channel string) string {
+	channel = trim(channel)
+	if i := strings.IndexByte(channel, '/'); i >= 0 {
+		channel = channel[:i]
+	}
+	return channel
+}
+
+// ------------------------------------------------------------------------------------
+
+// DefaultBuckets are the upper bounds, in seconds, of the histogram buckets used by
+// the OpenMetrics exporter.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// OpenMetrics keeps the measurements in memory and exposes them in the OpenMetrics text
+// format. It implements http.Handler, so it can be mounted on an existing HTTP server.
+type OpenMetrics struct {
+	sync.Mutex
+	buckets  []float64          // The upper bounds of the histogram buckets
+	families map[string]*family // The metric families, by name
+}
+
+// family represents a metric along with its series.
+type family struct {
+	kind   string             // The type of the metric
+	series map[string]*series // The series, by formatted labels
+}
+
+// series represents the value of a metric for a set of labels.
+type series struct {
+	labels  []Label
+	value   float64  // The value of a counter or a gauge, the sum of a histogram
+	count   uint64   // The number of observations of a histogram
+	buckets []uint64 // The cumulative counts of a histogram
+}
+
+// NewOpenMetrics creates a new OpenMetrics exporter. The histograms use the buckets
+// provided, or DefaultBuckets if none.
+func NewOpenMetrics(buckets ...float64) *OpenMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &OpenMetrics{
+		buckets:  buckets,
+		families: make(map[string]*family),
+	}
+}
+
+// Add increments a counter by the delta provided.
+func (m *OpenMetrics) Add(name string, delta float64, labels ...Label) {
+	m.Lock()
+	defer m.Unlock()
+	m.series("counter", name, labels).value += delta
+}
+
+// Set sets the value of a gauge.
+func (m *OpenMetrics) Set(name string, value float64, labels ...Label) {
+	m.Lock()
+	defer m.Unlock()
+	m.series("gauge", name, labels).value = value
+}
+
+// Observe records a value in a histogram.
+func (m *OpenMetrics) Observe(name string, value float64, labels ...Label) {
+	m.Lock()
+	defer m.Unlock()
+
+	s := m.series("histogram", name, labels)
+	s.value += value
+	s.count++
+	for i, le := range m.buckets {
+		if value <= le {
+			s.buckets[i]++
+		}
+	}
+}
+
+// series returns the series of a metric, creating it if necessary. The caller must hold
+// the lock.
+func (m *OpenMetrics) series(kind, name string, labels []Label) *series {
+	f, ok := m.families[name]
+	if !ok {
+		f = &family{kind: kind, series: make(map[string]*series)}
+		m.families[name] = f
+	}
+
+	key := formatLabels(labels)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: append([]Label{}, labels...)}
+		if kind == "histogram" {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// WriteTo writes the measurements in the OpenMetrics text format.
+func (m *OpenMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var sb strings.Builder
+	for _, name := range sortedKeys(m.families) {
+		f := m.families[name]
+		if help, ok := metricHelp[name]; ok {
+			fmt.Fprintf(&sb, "# HELP %s %s\n", name, help)
+		}
+
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", name, f.kind)
+		for _, key := range sortedKeys(f.series) {
+			s := f.series[key]
+			switch f.kind {
+			case "counter":
+				fmt.Fprintf(&sb, "%s_total%s %s\n", name, key, formatFloat(s.value))
+			case "gauge":
+				fmt.Fprintf(&sb, "%s%s %s\n", name, key, formatFloat(s.value))
+			case "histogram":
+				for i, le := range m.buckets {
+					fmt.Fprintf(&sb, "%s_bucket%s %d\n", name, formatLabels(s.labels, Label{"le", formatFloat(le)}), s.buckets[i])
+				}
+				fmt.Fprintf(&sb, "%s_bucket%s %d\n", name, formatLabels(s.labels, Label{"le", "+Inf"}), s.count)
+				fmt.Fprintf(&sb, "%s_sum%s %s\n", name, key, formatFloat(s.value))
+				fmt.Fprintf(&sb, "%s_count%s %d\n", name, key, s.count)
+			}
+		}
+	}
+
+	sb.WriteString("# EOF\n")
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// ServeHTTP writes the measurements in the OpenMetrics text format.
+func (m *OpenMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// formatFloat formats a value in the OpenMetrics text format.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// sortedKeys returns the keys of a map, in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// ------------------------------------------------------------------------------------
+
+// Expvar records the measurements in an expvar map, which is served as JSON by the
+// expvar package on /debug/vars. A histogram is recorded as its sum and count.
+type Expvar struct {
+	sync.Mutex
+	vars *expvar.Map
+}
+
+// NewExpvar creates a metrics adapter which records the measurements in the expvar map
+// provided.
+func NewExpvar(vars *expvar.Map) *Expvar {
+	return &Expvar{vars: vars}
+}
+
+// Add increments a counter by the delta provided.
+func (m *Expvar) Add(name string, delta float64, labels ...Label) {
+	m.vars.AddFloat(name+formatLabels(labels), delta)
+}
+
+// Set sets the value of a gauge.
+func (m *Expvar) Set(name string, value float64, labels ...Label) {
+	key := name + formatLabels(labels)
+
+	m.Lock()
+	defer m.Unlock()
+	v, ok := m.vars.Get(key).(*expvar.Float)
+	if !ok {
+		v = new(expvar.Float)
+		m.vars.Set(key, v)
+	}
+	v.Set(value)
+}
+
+// Observe records a value in a histogram, as its sum and count.
+func (m *Expvar) Observe(name string, value float64, labels ...Label) {
+	key := formatLabels(labels)
+	m.vars.AddFloat(name+"_sum"+key, value)
+	m.vars.Add(name+"_count"+key, 1)
+}
+
+// ------------------------------------------------------------------------------------
+
+// measure records the outcome of an operation which failed with the error provided.
+func (c *Client) measure(operation string, err error) {
+	if errors.Is(err, ErrTimeout) {
+		c.metrics.Add(MetricTimeouts, 1, Label{"operation", operation})
+	}
+}
+
+// measurePublish records a publish to a channel, or a link, once it completed.
+func (c *Client) measurePublish(channel string, payload interface{}, err error) {
+	if err != nil {
+		c.measure("publish", err)
+		return
+	}
+
+	c.metrics.Add(MetricMessagesPublished, 1, Label{"prefix", channelPrefix(channel)})
+	if b, err := toBytes(payload); err == nil {
+		c.metrics.Add(MetricBytesSent, float64(len(b)))
+	}
+}
+
+// since returns the seconds elapsed since the time provided.
+func since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
@@ -0,0 +1,77 @@
+package emitter
+
+import (
+	"bytes"
+	"expvar"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenMetrics(t *testing.T) {
+	m := NewOpenMetrics(0.1, 1)
+	m.Add(MetricMessagesPublished, 1, Label{"prefix", "a"})
+	m.Add(MetricMessagesPublished, 2, Label{"prefix", "a"})
+	m.Set(MetricSubscriptions, 3)
+	m.Observe(MetricRequestDuration, 0.05, Label{"operation", "keygen"})
+	m.Observe(MetricRequestDuration, 0.5, Label{"operation", "keygen"})
+
+	var buffer bytes.Buffer
+	m.WriteTo(&buffer)
+	assert.Equal(t, `# HELP emitter_messages_published The number of messages published, by channel prefix.
+# TYPE emitter_messages_published counter
+emitter_messages_published_total{prefix="a"} 3
+# HELP emitter_request_duration_seconds The latency of the requests made to the broker, by operation.
+# TYPE emitter_request_duration_seconds histogram
+emitter_request_duration_seconds_bucket{operation="keygen",le="0.1"} 1
+emitter_request_duration_seconds_bucket{operation="keygen",le="1"} 2
+emitter_request_duration_seconds_bucket{operation="keygen",le="+Inf"} 2
+emitter_request_duration_seconds_sum{operation="keygen"} 0.55
+emitter_request_duration_seconds_count{operation="keygen"} 2
+# HELP emitter_subscriptions The number of active subscriptions.
+# TYPE emitter_subscriptions gauge
+emitter_subscriptions 3
+# EOF
+`, buffer.String())
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/openmetrics-text")
+	assert.Equal(t, buffer.String(), w.Body.String())
+}
+
+func TestExpvar(t *testing.T) {
+	vars := new(expvar.Map)
+	m := NewExpvar(vars)
+	m.Add(MetricTimeouts, 1, Label{"operation", "me"})
+	m.Set(MetricSubscriptions, 2)
+	m.Set(MetricSubscriptions, 1)
+	m.Observe(MetricHandlerDuration, 0.25)
+	m.Observe(MetricHandlerDuration, 0.25)
+
+	assert.Equal(t, "1", vars.Get(`emitter_timeouts{operation="me"}`).String())
+	assert.Equal(t, "1", vars.Get("emitter_subscriptions").String())
+	assert.Equal(t, "0.5", vars.Get("emitter_handler_duration_seconds_sum").String())
+	assert.Equal(t, "2", vars.Get("emitter_handler_duration_seconds_count").String())
+}
+
+func TestClientMetrics(t *testing.T) {
+	m := NewOpenMetrics()
+	c := NewClient(WithMetrics(m))
+	c.OnMessage(func(*Client, Message) {})
+	c.onMessage(nil, &message{topic: "a/b/", payload: "hello"})
+	c.onMessage(nil, &message{topic: "a/c/", payload: "hi"})
+
+	var buffer bytes.Buffer
+	m.WriteTo(&buffer)
+	assert.Contains(t, buffer.String(), `emitter_messages_received_total{prefix="a"} 2`)
+	assert.Contains(t, buffer.String(), "emitter_bytes_received_total 7")
+	assert.Contains(t, buffer.String(), `emitter_handler_duration_seconds_count{prefix="a"} 2`)
+}
+
+func TestChannelPrefix(t *testing.T) {
+	assert.Equal(t, "a", channelPrefix("a/b/c/"))
+	assert.Equal(t, "a", channelPrefix("/a/"))
+	assert.Equal(t, "a", channelPrefix("a"))
+}
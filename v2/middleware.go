@@ -0,0 +1,71 @@
+package emitter
+
+import (
+	"context"
+	"sync"
+)
+
+// Middleware wraps the handling of the inbound messages, for example to trace them, check
+// their origin or transform their payload. Calling the next handler is up to the
+// middleware, so a message can also be filtered out.
+type Middleware func(next MessageHandler) MessageHandler
+
+// Publication represents an outbound message, which the publish middleware can inspect
+// and modify before passing it to the next handler.
+type Publication struct {
+	Key     string      // The channel key, empty when publishing through a link
+	Channel string      // The channel, or the name of the link
+	Link    bool        // Whether the message is published through a link
+	Payload interface{} // The payload of the message
+	Options []Option    // The options of the publish
+}
+
+// PublishHandler publishes an outbound message.
+type PublishHandler func(ctx context.Context, p *Publication) error
+
+// PublishMiddleware wraps the publishing of the outbound messages. Calling the next
+// handler is up to the middleware, so a publish can also be rejected with an error.
+type PublishMiddleware func(next PublishHandler) PublishHandler
+
+// chain keeps track of the middleware of a client, in the order they were added.
+type chain struct {
+	sync.RWMutex
+	inbound  []Middleware
+	outbound []PublishMiddleware
+}
+
+// Use adds middleware around the handlers of the inbound messages, including the default
+// message handler. The first middleware added is the outermost one.
+func (c *Client) Use(middleware ...Middleware) {
+	c.chain.Lock()
+	defer c.chain.Unlock()
+	c.chain.inbound = append(c.chain.inbound, middleware...)
+}
+
+// UsePublish adds middleware around the publishes made with Publish, PublishWithLink and
+// their variants. The first middleware added is the outermost one.
+func (c *Client) UsePublish(middleware ...PublishMiddleware) {
+	c.chain.Lock()
+	defer c.chain.Unlock()
+	c.chain.outbound = append(c.chain.outbound, middleware...)
+}
+
+// wrapHandler wraps a message handler with the inbound middleware.
+func (c *Client) wrapHandler(h MessageHandler) MessageHandler {
+	c.chain.RLock()
+	defer c.chain.RUnlock()
+	for i := len(c.chain.inbound) - 1; i >= 0; i-- {
+		h = c.chain.inbound[i](h)
+	}
+	return h
+}
+
+// wrapPublish wraps a publish handler with the outbound middleware.
+func (c *Client) wrapPublish(h PublishHandler) PublishHandler {
+	c.chain.RLock()
+	defer c.chain.RUnlock()
+	for i := len(c.chain.outbound) - 1; i >= 0; i-- {
+		h = c.chain.outbound[i](h)
+	}
+	return h
+}
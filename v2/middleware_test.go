@@ -0,0 +1,80 @@
+package emitter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInboundMiddleware(t *testing.T) {
+	c := NewClient()
+
+	var trace []string
+	c.Use(func(next MessageHandler) MessageHandler {
+		return func(c *Client, m Message) {
+			trace = append(trace, "outer")
+			next(c, m)
+		}
+	}, func(next MessageHandler) MessageHandler {
+		return func(c *Client, m Message) {
+			trace = append(trace, "inner")
+			if string(m.Payload()) != "blocked" {
+				next(c, &message{topic: m.Topic(), payload: strings.ToUpper(string(m.Payload()))})
+			}
+		}
+	})
+
+	c.OnMessage(func(_ *Client, m Message) {
+		trace = append(trace, "default:"+string(m.Payload()))
+	})
+	c.handlers.AddHandler("a/", func(_ *Client, m Message) {
+		trace = append(trace, "a:"+string(m.Payload()))
+	})
+
+	c.onMessage(nil, &message{topic: "a/", payload: "hello"})
+	c.onMessage(nil, &message{topic: "b/", payload: "hi"})
+	c.onMessage(nil, &message{topic: "a/", payload: "blocked"})
+	assert.Equal(t, []string{
+		"outer", "inner", "a:HELLO",
+		"outer", "inner", "default:HI",
+		"outer", "inner",
+	}, trace)
+}
+
+func TestOutboundMiddleware(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	received := make(chan string, 1)
+	c := NewClient(WithBrokers(srv.URL))
+	c.UsePublish(func(next PublishHandler) PublishHandler {
+		return func(ctx context.Context, p *Publication) error {
+			if p.Channel == "forbidden/" {
+				return errors.New("forbidden")
+			}
+
+			p.Payload = strings.ToUpper(p.Payload.(string))
+			return next(ctx, p)
+		}
+	})
+
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+	assert.NoError(t, c.Subscribe("key", "a/", func(_ *Client, m Message) {
+		received <- string(m.Payload())
+	}))
+
+	assert.EqualError(t, c.Publish("key", "forbidden/", "hello"), "forbidden")
+	assert.NoError(t, c.Publish("key", "a/", "hello"))
+	select {
+	case payload := <-received:
+		assert.Equal(t, "HELLO", payload)
+	case <-time.After(time.Second):
+		assert.Fail(t, "the message was not received")
+	}
+}
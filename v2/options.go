@@ -2,6 +2,8 @@ package emitter
 
 import (
 	"crypto/tls"
+	"io"
+	"log/slog"
 	"net/url"
 	"strconv"
 	"time"
@@ -114,14 +116,149 @@ func WithAutoReconnect(a bool) func(*Client) {
 	}
 }
 
+// WithLogger sets the logger used for the diagnostics of the client, such as connection
+// losses or responses which could not be decoded. By default, the diagnostics are written
+// to the default logger of the "log/slog" package, a nil logger discards them.
+func WithLogger(logger *slog.Logger) func(*Client) {
+	return func(c *Client) {
+		if logger == nil {
+			logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		}
+		c.logger = logger
+	}
+}
+
+// WithMetrics sets the sink of the measurements made by the client, such as an OpenMetrics
+// exporter or an expvar adapter. By default, the measurements are discarded.
+func WithMetrics(metrics Metrics) func(*Client) {
+	return func(c *Client) {
+		c.metrics = metrics
+	}
+}
+
+// WithKeyProvider sets the provider of the keys, which is consulted whenever an operation
+// is made with an empty key.
+func WithKeyProvider(provider KeyProvider) func(*Client) {
+	return func(c *Client) {
+		c.keys = provider
+	}
+}
+
+// WithKeyGenerator generates the keys of the operations made with an empty key from a
+// secret key, caching them and refreshing them before their TTL expires. See
+// NewKeyGenerator for the details.
+func WithKeyGenerator(secret string, ttl time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.keys = NewKeyGenerator(c, secret, ttl)
+	}
+}
+
+// WithReconnectPolicy sets the policy which decides whether and when the client attempts
+// to reconnect after the connection was lost. It replaces the automatic reconnection and
+// the maximum reconnect interval of the MQTT client.
+func WithReconnectPolicy(policy ReconnectPolicy) func(*Client) {
+	return func(c *Client) {
+		c.reconnect = policy
+	}
+}
+
+// WithRetryPolicy sets the policy which decides whether and when publishes and requests
+// which failed with a transient error are attempted again. Publishes kept in a persistent
+// store are not retried, since they are sent again on the next connection. It also
+// decides when the durable consumers attempt a failed catch-up again.
+func WithRetryPolicy(policy RetryPolicy) func(*Client) {
+	return func(c *Client) {
+		c.retryPolicy = policy
+	}
+}
+
+// WithAutoResubscribe sets whether the active subscriptions should be re-issued when the
+// client reconnects to the broker. This is enabled by default.
+func WithAutoResubscribe(a bool) func(*Client) {
+	return func(c *Client) {
+		c.resub = a
+	}
+}
+
+// WithResubscribeHistory sets whether the subscriptions which are re-issued on reconnect
+// should keep their 'last' option and retrieve the message history again. This is enabled
+// by default.
+func WithResubscribeHistory(a bool) func(*Client) {
+	return func(c *Client) {
+		c.resubLast = a
+	}
+}
+
+// WithPersistentStore sets a directory in which the QoS1 publishes are persisted until
+// they are acknowledged by the broker. Publishes made while disconnected are queued and
+// the unacknowledged ones are sent again once the client (re)connects, including after
+// a restart of the process. A publish queued while disconnected returns nil, and one
+// which fails to be sent while connected returns an error wrapping ErrQueued, as it is
+// kept to be sent again; neither must be retried by the application.
+func WithPersistentStore(dir string) func(*Client) {
+	return func(c *Client) {
+		c.outbox = newOutbox(dir)
+	}
+}
+
+// WithOutboxLimits limits the total payload size (in bytes) and the age of the publishes
+// kept in the persistent store. When the size limit is reached, the policy decides whether
+// the oldest publishes are dropped or the new one is rejected with ErrOutboxFull. A zero
+// value disables the corresponding limit. The limits only apply along with
+// WithPersistentStore.
+func WithOutboxLimits(maxBytes int64, maxAge time.Duration, policy OverflowPolicy) func(*Client) {
+	return func(c *Client) {
+		c.limits = &outboxLimits{
+			maxBytes: maxBytes,
+			maxAge:   maxAge,
+			policy:   policy,
+		}
+	}
+}
+
+// WithDispatcher runs the message handlers on a pool of workers instead of the network
+// goroutine, so that a slow handler does not stall the other channels and the responses
+// of the broker. The messages of a channel are handled in order by the same worker, and
+// each worker queues up to queueSize messages. When a queue is full, the policy decides
+// whether the oldest or the newest message is dropped, or the network goroutine waits.
+// Once the client is disconnected, the workers stop after handling the queued messages,
+// without the disconnection waiting for them.
+func WithDispatcher(workers, queueSize int, policy OverflowPolicy) func(*Client) {
+	return func(c *Client) {
+		c.dispatcher = newDispatcher(workers, queueSize, policy)
+	}
+}
+
+// WithDispatchKey sets the function which returns the ordering key of a message, the
+// messages which share a key being handled in order. By default, the key is the topic
+// of the message. The key only applies along with WithDispatcher.
+func WithDispatchKey(key func(Message) string) func(*Client) {
+	return func(c *Client) {
+		c.dispatchKey = key
+	}
+}
+
+// WithDeadLetter publishes the messages which a handler failed to process, because it
+// returned an error or panicked, to a dead-letter channel. The message is published as
+// a JSON document carrying its original topic, payload and the error. The failures of
+// the handlers of the dead-letter channel itself are only reported.
+func WithDeadLetter(key, channel string) func(*Client) {
+	return func(c *Client) {
+		c.deadLetter = &deadLetter{Key: key, Channel: channel}
+	}
+}
+
 // option represents a key/value pair that can be supplied to the publish/subscribe or unsubscribe
 // methods and provide ways to configure the operation.
 type option string
 
 const (
-	withRetain = option("+r")
-	withQos0   = option("+0")
-	withQos1   = option("+1")
+	withRetain     = option("+r")
+	withQos0       = option("+0")
+	withQos1       = option("+1")
+	withBlock      = option("+b")
+	withDropNewest = option("+n")
+	withDropOldest = option("+o")
 )
 
 // String converts the option to a string.
@@ -159,6 +296,19 @@ func WithAtLeastOnce() Option {
 	return withQos1
 }
 
+// WithOverflow sets the policy to apply when the buffer of SubscribeChan or Messages is
+// full. By default, the subscription waits for the consumer.
+func WithOverflow(policy OverflowPolicy) Option {
+	switch policy {
+	case DropNewest:
+		return withDropNewest
+	case DropOldest:
+		return withDropOldest
+	default:
+		return withBlock
+	}
+}
+
 func getUTCTimestamp(input time.Time) int64 {
 	t := input
 	if zone, _ := t.Zone(); zone != "UTC" {
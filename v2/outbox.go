@@ -0,0 +1,385 @@
+package emitter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The maximum size of a segment file before the outbox is compacted.
+const segmentSize = 4 << 20
+
+// outbox is a durable queue of QoS1 publishes, persisted in a directory as a set of
+// append-only segment files. A publish remains in the outbox until the broker has
+// acknowledged it, so it survives both disconnections and process restarts.
+type outbox struct {
+	sync.Mutex
+	dir      string               // The directory of the segment files
+	maxBytes int64                // The maximum size of the pending payloads, 0 for unlimited
+	maxAge   time.Duration        // The maximum age of a pending publish, 0 for unlimited
+	policy   OverflowPolicy       // The policy to apply when the outbox is full
+	pending  map[uint64]*envelope // The pending publishes, by ID
+	inflight map[uint64]bool      // The publishes which are currently being sent
+	size     int64                // The size of the pending payloads
+	next     uint64               // The next publish ID
+	file     *os.File             // The segment currently appended to
+	written  int64                // The size of the current segment
+	segment  int                  // The number of the current segment
+}
+
+// envelope represents a record of a segment file, which is either a publish or an
+// acknowledgement of a previous publish.
+type envelope struct {
+	ID      uint64 `json:"id"`
+	Topic   string `json:"topic,omitempty"`
+	Payload []byte `json:"payload,omitempty"`
+	Retain  bool   `json:"retain,omitempty"`
+	Time    int64  `json:"time,omitempty"`
+	Ack     bool   `json:"ack,omitempty"`
+}
+
+// outboxLimits represents the limits of the persistent store, which are kept by the
+// client until the outbox is built.
+type outboxLimits struct {
+	maxBytes int64          // The maximum size of the pending payloads, 0 for unlimited
+	maxAge   time.Duration  // The maximum age of a pending publish, 0 for unlimited
+	policy   OverflowPolicy // The policy to apply when the outbox is full
+}
+
+// newOutbox creates a new outbox, the directory is opened on first use.
+func newOutbox(dir string) *outbox {
+	return &outbox{
+		dir:    dir,
+		policy: DropOldest,
+	}
+}
+
+// open loads the pending publishes from the segment files, if the outbox is not yet
+// opened. The caller must hold the lock.
+func (o *outbox) open() error {
+	if o.pending != nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(o.dir, 0o755); err != nil {
+		return err
+	}
+
+	segments, err := o.segments()
+	if err != nil {
+		return err
+	}
+
+	// Replay every segment, in order, to rebuild the pending publishes
+	o.pending = make(map[uint64]*envelope)
+	o.inflight = make(map[uint64]bool)
+	for _, name := range segments {
+		if err := o.replay(name); err != nil {
+			return err
+		}
+	}
+
+	if n := len(segments); n > 0 {
+		fmt.Sscanf(filepath.Base(segments[n-1]), "outbox-%d.log", &o.segment)
+	}
+
+	return o.compact()
+}
+
+// replay applies the records of a segment file. A truncated last record, which may
+// be left by a crash, is ignored.
+func (o *outbox) replay(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var e envelope
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+
+		if e.ID >= o.next {
+			o.next = e.ID + 1
+		}
+
+		switch {
+		case e.Ack:
+			o.remove(e.ID)
+		default:
+			o.pending[e.ID] = &e
+			o.size += int64(len(e.Payload))
+		}
+	}
+	return scanner.Err()
+}
+
+// segments returns the segment files of the directory, in order.
+func (o *outbox) segments() ([]string, error) {
+	segments, err := filepath.Glob(filepath.Join(o.dir, "outbox-*.log"))
+	sort.Strings(segments)
+	return segments, err
+}
+
+// compact writes the pending publishes into a new segment and removes the older
+// segments. The caller must hold the lock.
+func (o *outbox) compact() error {
+	old, err := o.segments()
+	if err != nil {
+		return err
+	}
+
+	if o.file != nil {
+		o.file.Close()
+		o.file = nil
+	}
+
+	o.segment++
+	name := filepath.Join(o.dir, fmt.Sprintf("outbox-%010d.log", o.segment))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	o.file, o.written = f, 0
+	for _, e := range o.ordered() {
+		if err := o.append(e, false); err != nil {
+			return err
+		}
+	}
+
+	if err := o.file.Sync(); err != nil {
+		return err
+	}
+
+	for _, name := range old {
+		os.Remove(name)
+	}
+	return nil
+}
+
+// append writes a record to the current segment. The caller must hold the lock.
+func (o *outbox) append(e *envelope, sync bool) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	n, err := o.file.Write(append(b, '\n'))
+	o.written += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if sync {
+		return o.file.Sync()
+	}
+	return nil
+}
+
+// remove removes a pending publish from memory. The caller must hold the lock.
+func (o *outbox) remove(id uint64) {
+	if e, ok := o.pending[id]; ok {
+		o.size -= int64(len(e.Payload))
+		delete(o.pending, id)
+		delete(o.inflight, id)
+	}
+}
+
+// ordered returns the pending publishes, oldest first. The caller must hold the lock.
+func (o *outbox) ordered() []*envelope {
+	out := make([]*envelope, 0, len(o.pending))
+	for _, e := range o.pending {
+		out = append(out, e)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// idle returns the oldest pending publish which is not inflight, which may be dropped
+// without acknowledging a publish that is being sent. The caller must hold the lock.
+func (o *outbox) idle() (uint64, bool) {
+	for _, e := range o.ordered() {
+		if !o.inflight[e.ID] {
+			return e.ID, true
+		}
+	}
+	return 0, false
+}
+
+// ack writes an acknowledgement and removes a pending publish. The caller must hold
+// the lock.
+func (o *outbox) ack(id uint64) error {
+	if _, ok := o.pending[id]; !ok {
+		return nil
+	}
+
+	o.remove(id)
+	if err := o.append(&envelope{ID: id, Ack: true}, false); err != nil {
+		return err
+	}
+
+	if o.written > segmentSize {
+		return o.compact()
+	}
+	return nil
+}
+
+// expire drops the pending publishes which are older than the maximum age. The caller
+// must hold the lock.
+func (o *outbox) expire() error {
+	if o.maxAge <= 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(-o.maxAge).UnixNano()
+	for _, e := range o.ordered() {
+		if e.Time < deadline && !o.inflight[e.ID] {
+			if err := o.ack(e.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Enqueue persists a publish and returns its identifier. When the outbox is full, either
+// the oldest publishes are dropped or ErrOutboxFull is returned, depending on the policy.
+// The inflight publishes are never dropped, so ErrOutboxFull is also returned when only
+// those are left.
+// A publish which is enqueued as inflight is not returned by Acquire until released.
+func (o *outbox) Enqueue(topic string, payload []byte, retain, inflight bool) (uint64, error) {
+	o.Lock()
+	defer o.Unlock()
+	if err := o.open(); err != nil {
+		return 0, err
+	}
+
+	if err := o.expire(); err != nil {
+		return 0, err
+	}
+
+	// Make some room if necessary
+	if o.maxBytes > 0 && int64(len(payload)) > o.maxBytes {
+		return 0, ErrOutboxFull
+	}
+
+	for o.maxBytes > 0 && o.size+int64(len(payload)) > o.maxBytes {
+		oldest, ok := o.idle()
+		if o.policy != DropOldest || !ok {
+			return 0, ErrOutboxFull
+		}
+
+		if err := o.ack(oldest); err != nil {
+			return 0, err
+		}
+	}
+
+	e := &envelope{
+		ID:      o.next,
+		Topic:   topic,
+		Payload: payload,
+		Retain:  retain,
+		Time:    time.Now().UnixNano(),
+	}
+
+	if err := o.append(e, true); err != nil {
+		return 0, err
+	}
+
+	o.next++
+	o.pending[e.ID] = e
+	o.size += int64(len(payload))
+	if inflight {
+		o.inflight[e.ID] = true
+	}
+	return e.ID, nil
+}
+
+// Ack removes a publish which was acknowledged by the broker.
+func (o *outbox) Ack(id uint64) error {
+	o.Lock()
+	defer o.Unlock()
+	if err := o.open(); err != nil {
+		return err
+	}
+
+	return o.ack(id)
+}
+
+// Release marks a publish which failed to be sent as pending again, so it is sent on
+// the next connection.
+func (o *outbox) Release(id uint64) {
+	o.Lock()
+	defer o.Unlock()
+	delete(o.inflight, id)
+}
+
+// Acquire returns the pending publishes which are not inflight, oldest first, and marks
+// them as inflight. Each of them must be either acknowledged or released.
+func (o *outbox) Acquire() ([]*envelope, error) {
+	o.Lock()
+	defer o.Unlock()
+	if err := o.open(); err != nil {
+		return nil, err
+	}
+
+	if err := o.expire(); err != nil {
+		return nil, err
+	}
+
+	var out []*envelope
+	for _, e := range o.ordered() {
+		if !o.inflight[e.ID] {
+			o.inflight[e.ID] = true
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// Len returns the number of pending publishes.
+func (o *outbox) Len() int {
+	o.Lock()
+	defer o.Unlock()
+	return len(o.pending)
+}
+
+// Close closes the current segment file.
+func (o *outbox) Close() error {
+	o.Lock()
+	defer o.Unlock()
+	if o.file == nil {
+		return nil
+	}
+
+	err := o.file.Close()
+	o.file, o.pending = nil, nil
+	return err
+}
+
+// toBytes converts a payload to a slice of bytes, accepting the same types as the
+// underlying MQTT client.
+func toBytes(payload interface{}) ([]byte, error) {
+	switch p := payload.(type) {
+	case string:
+		return []byte(p), nil
+	case []byte:
+		return p, nil
+	case bytes.Buffer:
+		return p.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("emitter: unknown payload type %T", payload)
+	}
+}
@@ -0,0 +1,162 @@
+package emitter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxReopen(t *testing.T) {
+	dir := t.TempDir()
+	o := newOutbox(dir)
+
+	id1, err := o.Enqueue("key/a/", []byte("one"), false, false)
+	assert.NoError(t, err)
+	id2, err := o.Enqueue("key/a/", []byte("two"), true, false)
+	assert.NoError(t, err)
+	assert.NoError(t, o.Ack(id1))
+	assert.NoError(t, o.Close())
+
+	// Simulate a record truncated by a crash
+	segments, _ := filepath.Glob(filepath.Join(dir, "outbox-*.log"))
+	assert.Len(t, segments, 1)
+	f, _ := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0o644)
+	f.WriteString(`{"id":9,"topic":"key/`)
+	f.Close()
+
+	o = newOutbox(dir)
+	pending, err := o.Acquire()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, id2, pending[0].ID)
+	assert.Equal(t, "two", string(pending[0].Payload))
+	assert.True(t, pending[0].Retain)
+
+	// Inflight publishes are not acquired twice, until released
+	pending, _ = o.Acquire()
+	assert.Empty(t, pending)
+	o.Release(id2)
+	pending, _ = o.Acquire()
+	assert.Len(t, pending, 1)
+
+	// New identifiers never reuse the persisted ones
+	id3, err := o.Enqueue("key/a/", []byte("three"), false, false)
+	assert.NoError(t, err)
+	assert.Greater(t, id3, id2)
+}
+
+func TestOutboxLimits(t *testing.T) {
+	o := newOutbox(t.TempDir())
+	o.maxBytes = 6
+
+	_, err := o.Enqueue("key/a/", []byte("abc"), false, false)
+	assert.NoError(t, err)
+	_, err = o.Enqueue("key/a/", []byte("def"), false, false)
+	assert.NoError(t, err)
+	_, err = o.Enqueue("key/a/", []byte("ghi"), false, false)
+	assert.NoError(t, err)
+
+	pending, _ := o.Acquire()
+	assert.Len(t, pending, 2)
+	assert.Equal(t, "def", string(pending[0].Payload))
+
+	o.policy = DropNewest
+	_, err = o.Enqueue("key/a/", []byte("jkl"), false, false)
+	assert.Equal(t, ErrOutboxFull, err)
+
+	_, err = o.Enqueue("key/a/", []byte("too large"), false, false)
+	assert.Equal(t, ErrOutboxFull, err)
+}
+
+func TestOutboxLimitsInflight(t *testing.T) {
+	o := newOutbox(t.TempDir())
+	o.maxBytes = 6
+
+	// The publishes being sent are never dropped to make room
+	sent, err := o.Enqueue("key/a/", []byte("abc"), false, true)
+	assert.NoError(t, err)
+	_, err = o.Enqueue("key/a/", []byte("def"), false, false)
+	assert.NoError(t, err)
+	_, err = o.Enqueue("key/a/", []byte("ghi"), false, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, o.Len())
+	assert.NoError(t, o.Ack(sent))
+
+	pending, _ := o.Acquire()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, "ghi", string(pending[0].Payload))
+
+	_, err = o.Enqueue("key/a/", []byte("jklmno"), false, false)
+	assert.Equal(t, ErrOutboxFull, err)
+}
+
+func TestOutboxLimitsOption(t *testing.T) {
+	c := NewClient(WithOutboxLimits(6, time.Minute, DropNewest), WithPersistentStore(t.TempDir()))
+	assert.Equal(t, int64(6), c.outbox.maxBytes)
+	assert.Equal(t, time.Minute, c.outbox.maxAge)
+	assert.Equal(t, DropNewest, c.outbox.policy)
+}
+
+func TestOutboxMaxAge(t *testing.T) {
+	o := newOutbox(t.TempDir())
+	o.maxAge = time.Millisecond
+
+	_, err := o.Enqueue("key/a/", []byte("old"), false, false)
+	assert.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	pending, err := o.Acquire()
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+	assert.Equal(t, 0, o.Len())
+}
+
+func TestPublishOffline(t *testing.T) {
+	const key = "outbox-key"
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	// Publish while disconnected, the message is persisted
+	dir := t.TempDir()
+	a := NewClient(WithBrokers(srv.URL), WithPersistentStore(dir))
+	assert.NoError(t, a.Publish(key, "outbox/", "queued", WithAtLeastOnce(), WithTTL(60)))
+	assert.Equal(t, 1, a.outbox.Len())
+
+	// Once connected, the message is sent and acknowledged
+	assert.NoError(t, a.Connect())
+	defer a.Disconnect(0)
+	assert.Eventually(t, func() bool { return a.outbox.Len() == 0 }, time.Second, 10*time.Millisecond)
+
+	b, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer b.Disconnect(0)
+
+	var payloads []string
+	for m, err := range b.History(key, "outbox/", 0, time.Now().Add(time.Minute).Unix(), 1) {
+		assert.NoError(t, err)
+		payloads = append(payloads, string(m.Payload))
+	}
+	assert.Equal(t, []string{"queued"}, payloads)
+}
+
+func TestPublishQueued(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c := NewClient(WithBrokers(srv.URL), WithPersistentStore(t.TempDir()))
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+
+	// A publish which fails while connected is kept, and reported as queued
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := c.PublishContext(ctx, "key", "outbox/", "kept", WithAtLeastOnce())
+	assert.ErrorIs(t, err, ErrQueued)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, c.outbox.Len())
+}
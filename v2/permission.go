@@ -0,0 +1,115 @@
+package emitter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Permission represents a set of permissions granted by a key.
+type Permission uint8
+
+// Various permissions
+const (
+	PermRead     Permission = 1 << iota // Subscribe to the channel, "r"
+	PermWrite                           // Publish to the channel, "w"
+	PermStore                           // Store the messages published, "s"
+	PermLoad                            // Load the stored messages, "l"
+	PermPresence                        // Query the presence on the channel, "p"
+	PermExtend                          // Extend the channel with private sub-channels, "e"
+	PermExecute                         // Execute functions on the channel, "x"
+)
+
+// The letters of the permissions, in the order of their bits.
+const permissionLetters = "rwslpex"
+
+// ParsePermission parses a set of permissions written with the emitter letters, such as
+// "rw" or "rwslp".
+func ParsePermission(text string) (Permission, error) {
+	var p Permission
+	for _, c := range text {
+		i := strings.IndexRune(permissionLetters, c)
+		if i < 0 {
+			return 0, fmt.Errorf("%w, the permission '%c' is unknown", ErrInvalidPermission, c)
+		}
+		p |= 1 << i
+	}
+
+	return p, p.Validate()
+}
+
+// String returns the letters of the permissions, in the canonical order.
+func (p Permission) String() string {
+	var sb strings.Builder
+	for i := range permissionLetters {
+		if p&(1<<i) != 0 {
+			sb.WriteByte(permissionLetters[i])
+		}
+	}
+	return sb.String()
+}
+
+// Has checks whether the set contains all of the permissions provided.
+func (p Permission) Has(other Permission) bool {
+	return p&other == other
+}
+
+// Validate checks that the set contains at least one permission, and only known ones.
+func (p Permission) Validate() error {
+	switch {
+	case p == 0:
+		return fmt.Errorf("%w, no permission is granted", ErrInvalidPermission)
+	case p >= 1<<len(permissionLetters):
+		return fmt.Errorf("%w, the set 0x%x contains unknown permissions", ErrInvalidPermission, uint8(p))
+	default:
+		return nil
+	}
+}
+
+// GeneratedKey represents a key generated by the broker.
+type GeneratedKey struct {
+	Key         string     // The generated key
+	Channel     string     // The channel the key grants access to, as normalized by the broker
+	Permissions Permission // The permissions granted
+	Expires     time.Time  // The time the key expires, zero if it never does
+}
+
+// GenerateChannelKey generates a key granting the permissions on a channel, using the
+// secret key provided. The key expires after the TTL, rounded up to the second, or never
+// if the TTL is zero.
+func (c *Client) GenerateChannelKey(ctx context.Context, secret, channel string, permissions Permission, ttl time.Duration) (*GeneratedKey, error) {
+	if err := permissions.Validate(); err != nil {
+		return nil, err
+	}
+
+	if ttl < 0 {
+		return nil, fmt.Errorf("emitter: the ttl of a key cannot be negative, got %s", ttl)
+	}
+
+	ttl = roundTTL(ttl)
+	start := time.Now()
+	key, normalized, err := c.GenerateKeyContext(ctx, secret, channel, permissions.String(), int(ttl.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &GeneratedKey{
+		Key:         key,
+		Channel:     normalized,
+		Permissions: permissions,
+	}
+	if ttl > 0 {
+		result.Expires = start.Add(ttl)
+	}
+	return result, nil
+}
+
+// roundTTL rounds a TTL up to the second, the precision of the broker, so that a TTL
+// under a second does not become zero, which never expires.
+func roundTTL(ttl time.Duration) time.Duration {
+	if rounded := ttl.Truncate(time.Second); rounded < ttl {
+		return rounded + time.Second
+	}
+	return ttl
+}
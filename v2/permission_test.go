@@ -0,0 +1,74 @@
+package emitter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermission(t *testing.T) {
+	p := PermRead | PermWrite | PermPresence
+	assert.Equal(t, "rwp", p.String())
+	assert.True(t, p.Has(PermRead|PermWrite))
+	assert.False(t, p.Has(PermStore))
+
+	for _, text := range []string{"r", "rw", "rwslpex"} {
+		parsed, err := ParsePermission(text)
+		assert.NoError(t, err)
+		assert.Equal(t, text, parsed.String())
+	}
+
+	parsed, err := ParsePermission("wr")
+	assert.NoError(t, err)
+	assert.Equal(t, PermRead|PermWrite, parsed)
+
+	for _, text := range []string{"", "rz"} {
+		_, err := ParsePermission(text)
+		assert.True(t, errors.Is(err, ErrInvalidPermission), text)
+	}
+
+	assert.ErrorIs(t, Permission(0x80).Validate(), ErrInvalidPermission)
+}
+
+func TestRoundTTL(t *testing.T) {
+	assert.Equal(t, time.Duration(0), roundTTL(0))
+	assert.Equal(t, time.Second, roundTTL(time.Nanosecond))
+	assert.Equal(t, time.Second, roundTTL(time.Second))
+	assert.Equal(t, 2*time.Second, roundTTL(1500*time.Millisecond))
+}
+
+func TestGenerateChannelKey(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c := NewClient(WithBrokers(srv.URL))
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+
+	ctx := context.Background()
+	start := time.Now()
+	key, err := c.GenerateChannelKey(ctx, "secret", "/a/b", PermRead|PermLoad, 90*time.Second+time.Millisecond)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, key.Key)
+	assert.Equal(t, "a/b/", key.Channel)
+	assert.Equal(t, PermRead|PermLoad, key.Permissions)
+	assert.WithinDuration(t, start.Add(91*time.Second), key.Expires, time.Second)
+
+	// A TTL under a second does not become a key which never expires
+	key, err = c.GenerateChannelKey(ctx, "secret", "a/", PermWrite, time.Millisecond)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, start.Add(time.Second), key.Expires, time.Second)
+
+	key, err = c.GenerateChannelKey(ctx, "secret", "a/", PermWrite, 0)
+	assert.NoError(t, err)
+	assert.True(t, key.Expires.IsZero())
+
+	_, err = c.GenerateChannelKey(ctx, "secret", "a/", 0, 0)
+	assert.ErrorIs(t, err, ErrInvalidPermission)
+	_, err = c.GenerateChannelKey(ctx, "secret", "a/", PermRead, -time.Second)
+	assert.Error(t, err)
+}
@@ -0,0 +1,219 @@
+package emitter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ReconnectPolicy decides whether and when the client attempts to reconnect after the
+// connection was lost.
+type ReconnectPolicy interface {
+
+	// NextReconnect returns the delay before the n-th reconnection attempt, starting at 1,
+	// or false to give up. The error is the reason of the last failure.
+	NextReconnect(attempt int, err error) (time.Duration, bool)
+}
+
+// RetryPolicy decides whether and when an operation which failed with a transient error,
+// such as ErrTimeout, is attempted again.
+type RetryPolicy interface {
+
+	// NextRetry returns the delay before the n-th retry of an operation, starting at 1,
+	// or false to give up. The error is the reason of the last failure.
+	NextRetry(operation string, attempt int, err error) (time.Duration, bool)
+}
+
+// Attempt represents an attempt to reconnect or to retry an operation.
+type Attempt struct {
+	Operation string        // The operation, "reconnect", "publish", "consume" or the request name
+	Number    int           // The number of the attempt, starting at 1
+	Delay     time.Duration // The delay before the attempt
+	Err       error         // The error which caused the attempt
+}
+
+// AttemptHandler is a callback that is called before each reconnection attempt or
+// retry. Returning false aborts the reconnection or the operation, for example when
+// the application is shutting down.
+type AttemptHandler func(*Client, Attempt) bool
+
+// GiveUpHandler is a callback that is called when a policy gives up reconnecting or
+// retrying an operation.
+type GiveUpHandler func(*Client, Attempt)
+
+// ------------------------------------------------------------------------------------
+
+// Backoff is an exponential backoff with jitter, which can be used both as a reconnect
+// and as a retry policy.
+type Backoff struct {
+	Min         time.Duration // The delay before the first attempt
+	Max         time.Duration // The maximum delay between two attempts, unlimited if zero
+	Factor      float64       // The multiplier applied to the delay after each attempt, 2 if zero
+	Jitter      float64       // The fraction of the delay randomly added or removed, between 0 and 1
+	MaxAttempts int           // The maximum number of attempts, unlimited if zero
+}
+
+// Delay returns the delay before the n-th attempt, starting at 1.
+func (b *Backoff) Delay(attempt int) time.Duration {
+	factor := b.Factor
+	if factor <= 0 {
+		factor = 2
+	}
+
+	delay := float64(b.Min) * math.Pow(factor, float64(attempt-1))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// NextReconnect returns the delay before the n-th reconnection attempt.
+func (b *Backoff) NextReconnect(attempt int, _ error) (time.Duration, bool) {
+	return b.next(attempt)
+}
+
+// NextRetry returns the delay before the n-th retry of an operation.
+func (b *Backoff) NextRetry(_ string, attempt int, _ error) (time.Duration, bool) {
+	return b.next(attempt)
+}
+
+// next returns the delay before the n-th attempt, unless the attempts are exhausted.
+func (b *Backoff) next(attempt int) (time.Duration, bool) {
+	if b.MaxAttempts > 0 && attempt > b.MaxAttempts {
+		return 0, false
+	}
+	return b.Delay(attempt), true
+}
+
+// ------------------------------------------------------------------------------------
+
+// OnAttempt sets the function that will be called before each reconnection attempt or
+// retry made under a policy.
+func (c *Client) OnAttempt(handler AttemptHandler) {
+	c.attempt = handler
+}
+
+// OnGiveUp sets the function that will be called when a policy gives up reconnecting
+// or retrying an operation.
+func (c *Client) OnGiveUp(handler GiveUpHandler) {
+	c.giveUp = handler
+}
+
+// notifyAttempt notifies the attempt handler and returns whether to proceed.
+func (c *Client) notifyAttempt(a Attempt) bool {
+	return c.attempt == nil || c.attempt(c, a)
+}
+
+// notifyGiveUp notifies the give up handler.
+func (c *Client) notifyGiveUp(a Attempt) {
+	c.logger.Warn("emitter: giving up after "+strconv.Itoa(a.Number-1)+" attempt(s)",
+		slog.String("operation", a.Operation),
+		slog.Any("error", a.Err))
+
+	if c.giveUp != nil {
+		c.giveUp(c, a)
+	}
+}
+
+// reconnectLoop reconnects to the broker following the reconnect policy, until the
+// connection is established, the policy gives up or the client is disconnected.
+func (c *Client) reconnectLoop(cause error) {
+	closed := c.state.Closed()
+	for attempt := 1; ; attempt++ {
+		delay, ok := c.reconnect.NextReconnect(attempt, cause)
+		a := Attempt{Operation: "reconnect", Number: attempt, Delay: delay, Err: cause}
+		if !ok {
+			c.setState(Disconnected, cause)
+			c.notifyGiveUp(a)
+			return
+		}
+
+		if !c.notifyAttempt(a) {
+			c.setState(Disconnected, cause)
+			return
+		}
+
+		// Wait for the delay, unless the client is disconnected in the meantime
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-closed:
+			timer.Stop()
+			return
+		}
+
+		if c.State() != Reconnecting {
+			return // Disconnected in the meantime
+		}
+
+		ctx, cancel := c.withTimeout(context.Background())
+		err := c.do(ctx, c.conn.Connect())
+		cancel()
+		if err == nil {
+			if c.State() == Closed {
+				c.conn.Disconnect(0) // Disconnected while connecting
+			}
+			return
+		}
+
+		cause = err
+	}
+}
+
+// retry runs an operation, retrying it following the retry policy while it fails with
+// a transient error and the context is not done.
+func (c *Client) retry(ctx context.Context, operation string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || c.retryPolicy == nil || !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay, ok := c.retryPolicy.NextRetry(operation, attempt, err)
+		a := Attempt{Operation: operation, Number: attempt, Delay: delay, Err: err}
+		if !ok {
+			c.notifyGiveUp(a)
+			return err
+		}
+
+		if !c.notifyAttempt(a) {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// isTransient checks whether an error is transient, so the operation may succeed if
+// attempted again.
+func isTransient(err error) bool {
+	var netErr net.Error
+	var apiErr *Error
+	switch {
+	case errors.Is(err, ErrTimeout), errors.Is(err, ErrDisconnected), errors.Is(err, mqtt.ErrNotConnected):
+		return true
+	case errors.As(err, &apiErr):
+		return apiErr.Status >= 500
+	case errors.As(err, &netErr):
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,157 @@
+package emitter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := &Backoff{Min: 100 * time.Millisecond, Max: time.Second, MaxAttempts: 5}
+	assert.Equal(t, 100*time.Millisecond, b.Delay(1))
+	assert.Equal(t, 200*time.Millisecond, b.Delay(2))
+	assert.Equal(t, 400*time.Millisecond, b.Delay(3))
+	assert.Equal(t, time.Second, b.Delay(10))
+
+	_, ok := b.NextRetry("publish", 5, ErrTimeout)
+	assert.True(t, ok)
+	_, ok = b.NextReconnect(6, nil)
+	assert.False(t, ok)
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := b.Delay(1)
+		assert.GreaterOrEqual(t, delay, 50*time.Millisecond)
+		assert.LessOrEqual(t, delay, 150*time.Millisecond)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, isTransient(ErrTimeout))
+	assert.True(t, isTransient(mqtt.ErrNotConnected))
+	assert.True(t, isTransient(&RequestError{Operation: "me", Err: ErrDisconnected}))
+	assert.True(t, isTransient(&Error{Status: 503}))
+	assert.False(t, isTransient(&Error{Status: 401}))
+	assert.False(t, isTransient(context.Canceled))
+	assert.False(t, isTransient(errors.New("boom")))
+}
+
+func TestRetry(t *testing.T) {
+	c := NewClient(WithRetryPolicy(&Backoff{Min: time.Millisecond, MaxAttempts: 3}))
+
+	var attempts []Attempt
+	c.OnAttempt(func(_ *Client, a Attempt) bool {
+		attempts = append(attempts, a)
+		return true
+	})
+
+	// Transient errors are retried until the operation succeeds
+	calls := 0
+	err := c.retry(context.Background(), "publish", func() error {
+		if calls++; calls < 3 {
+			return ErrTimeout
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Len(t, attempts, 2)
+	assert.Equal(t, 2, attempts[1].Number)
+	assert.Equal(t, "publish", attempts[1].Operation)
+
+	// Other errors are not retried
+	calls = 0
+	err = c.retry(context.Background(), "keygen", func() error {
+		calls++
+		return &Error{Status: 401}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+
+	// The policy gives up after the maximum number of attempts
+	var gaveUp Attempt
+	c.OnGiveUp(func(_ *Client, a Attempt) { gaveUp = a })
+	calls = 0
+	err = c.retry(context.Background(), "presence", func() error {
+		calls++
+		return ErrTimeout
+	})
+	assert.Equal(t, ErrTimeout, err)
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, 4, gaveUp.Number)
+
+	// The attempt handler aborts the retries
+	c.OnAttempt(func(_ *Client, a Attempt) bool { return false })
+	calls = 0
+	c.retry(context.Background(), "publish", func() error {
+		calls++
+		return ErrTimeout
+	})
+	assert.Equal(t, 1, calls)
+}
+
+func TestReconnectPolicy(t *testing.T) {
+	srv := emittertest.NewServer()
+
+	attempts := make(chan Attempt, 10)
+	gaveUp := make(chan Attempt, 1)
+	c := NewClient(WithBrokers(srv.URL), WithConnectTimeout(time.Second),
+		WithReconnectPolicy(&Backoff{Min: 10 * time.Millisecond, MaxAttempts: 2}))
+	c.OnAttempt(func(_ *Client, a Attempt) bool {
+		attempts <- a
+		return true
+	})
+	c.OnGiveUp(func(_ *Client, a Attempt) { gaveUp <- a })
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+
+	// The client reconnects through the policy
+	srv.CloseClientConnections()
+	assert.Equal(t, "reconnect", (<-attempts).Operation)
+	assert.Eventually(t, func() bool { return c.State() == Connected }, time.Second, 10*time.Millisecond)
+
+	// Once the broker is gone, the policy gives up
+	srv.Close()
+	select {
+	case a := <-gaveUp:
+		assert.Equal(t, 3, a.Number)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the policy to give up")
+	}
+	assert.Equal(t, Disconnected, c.State())
+}
+
+func TestReconnectPolicyDisconnect(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	waiting := make(chan struct{})
+	c := NewClient(WithBrokers(srv.URL), WithReconnectPolicy(&Backoff{Min: time.Hour, Max: time.Hour}))
+	c.OnAttempt(func(*Client, Attempt) bool {
+		close(waiting)
+		return true
+	})
+
+	// Disconnecting interrupts the delay before the next attempt
+	done := make(chan struct{})
+	c.setState(Reconnecting, ErrDisconnected)
+	go func() {
+		defer close(done)
+		c.reconnectLoop(ErrDisconnected)
+	}()
+
+	<-waiting
+	c.Disconnect(0)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the reconnect loop to stop")
+	}
+	assert.Equal(t, Closed, c.State())
+	assert.Equal(t, 0, srv.Clients())
+}
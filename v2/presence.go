@@ -0,0 +1,513 @@
+package emitter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// MemberHandler is a callback type which can be set to be executed when a client joins
+// or leaves the channel of a presence tracker.
+type MemberHandler func(*PresenceTracker, PresenceInfo)
+
+// PresenceTracker keeps the roster of the clients subscribed to a channel up to date. It
+// is seeded with the presence status of the channel, applies the presence change events
+// as they arrive and re-synchronizes itself after the client reconnects.
+type PresenceTracker struct {
+	sync.Mutex
+	client  *Client                 // The client which tracks the channel
+	key     string                  // The key used to request the presence
+	channel string                  // The channel tracked, as normalized by the broker
+	members map[string]PresenceInfo // The clients subscribed, by identifier
+	join    MemberHandler           // User-defined join handler
+	leave   MemberHandler           // User-defined leave handler
+	events  serial                  // Delivers the joins and leaves in order
+}
+
+// TrackPresence requests the presence status of a channel along with its change events,
+// and returns a tracker seeded with the status. The key must grant the Presence
+// permission on the channel, which cannot contain wildcards.
+func (c *Client) TrackPresence(ctx context.Context, key, channel string) (*PresenceTracker, error) {
+	ch := NewChannel(channel)
+	if err := ch.Validate(); err != nil {
+		return nil, err
+	}
+
+	if ch.IsWildcard() {
+		return nil, fmt.Errorf("%w, the presence of '%s' cannot be tracked as it contains a wildcard", ErrInvalidChannel, ch.Name())
+	}
+
+	t := &PresenceTracker{
+		client:  c,
+		key:     key,
+		channel: ch.Name(),
+		members: make(map[string]PresenceInfo),
+	}
+
+	// Register the tracker first, so that no change event is missed
+	c.watches.trackers.Add(t)
+	if err := t.sync(ctx); err != nil {
+		c.watches.trackers.Remove(t)
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Channel returns the channel tracked.
+func (t *PresenceTracker) Channel() string {
+	return t.channel
+}
+
+// OnJoin sets the function that will be called when a client subscribes to the channel.
+// The joins and leaves are notified in order on a separate goroutine, so the handlers
+// may make requests.
+func (t *PresenceTracker) OnJoin(handler MemberHandler) {
+	t.Lock()
+	defer t.Unlock()
+	t.join = handler
+}
+
+// OnLeave sets the function that will be called when a client unsubscribes from the
+// channel.
+func (t *PresenceTracker) OnLeave(handler MemberHandler) {
+	t.Lock()
+	defer t.Unlock()
+	t.leave = handler
+}
+
+// Members returns the clients subscribed to the channel, ordered by identifier.
+func (t *PresenceTracker) Members() []PresenceInfo {
+	t.Lock()
+	defer t.Unlock()
+	return t.roster()
+}
+
+// IsOnline checks whether a client is subscribed to the channel.
+func (t *PresenceTracker) IsOnline(id string) bool {
+	t.Lock()
+	defer t.Unlock()
+	_, ok := t.members[id]
+	return ok
+}
+
+// Count returns the number of clients subscribed to the channel.
+func (t *PresenceTracker) Count() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.members)
+}
+
+// Close stops tracking the channel.
+func (t *PresenceTracker) Close() error {
+	return t.CloseContext(context.Background())
+}
+
+// CloseContext stops tracking the channel. If no other tracker or handler follows the
+// channel, it disables the presence change events and waits for the request to be
+// delivered until the context is done.
+func (t *PresenceTracker) CloseContext(ctx context.Context) error {
+	c := t.client
+	if !c.watches.trackers.Remove(t) || c.isWatching(t.channel) {
+		return nil
+	}
+
+	return c.disablePresence(ctx, t.key, t.channel)
+}
+
+// roster returns the clients subscribed, ordered by identifier. The tracker must be
+// locked.
+func (t *PresenceTracker) roster() []PresenceInfo {
+	members := make([]PresenceInfo, 0, len(t.members))
+	for _, m := range t.members {
+		members = append(members, m)
+	}
+
+	sortByID(members)
+	return members
+}
+
+// watching returns the channel tracked.
+func (t *PresenceTracker) watching() string {
+	return t.channel
+}
+
+// sync requests the presence status of the channel and replaces the roster with it,
+// calling the handlers for the clients which joined or left in the meantime.
+func (t *PresenceTracker) sync(ctx context.Context) error {
+	status, err := t.client.PresenceContext(ctx, t.key, t.channel, true, true)
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	changes := DiffPresence(&PresenceEvent{Who: t.roster()}, status)
+	t.members = make(map[string]PresenceInfo, len(status.Who))
+	for _, who := range status.Who {
+		t.members[who.ID] = who
+	}
+	join, leave := t.join, t.leave
+	t.Unlock()
+
+	for _, ev := range changes {
+		switch ev.Event {
+		case PresenceSubscribe:
+			t.notify(join, ev.Who[0])
+		case PresenceUnsubscribe:
+			t.notify(leave, ev.Who[0])
+		}
+	}
+	return nil
+}
+
+// apply applies a presence change event to the roster.
+func (t *PresenceTracker) apply(ev PresenceEvent) {
+	for _, who := range ev.Who {
+		t.Lock()
+		_, ok := t.members[who.ID]
+		var handler MemberHandler
+		switch {
+		case ev.Event == PresenceSubscribe && !ok:
+			t.members[who.ID] = who
+			handler = t.join
+		case ev.Event == PresenceUnsubscribe && ok:
+			delete(t.members, who.ID)
+			handler = t.leave
+		}
+		t.Unlock()
+
+		t.notify(handler, who)
+	}
+}
+
+// notify queues the call of a join or leave handler, if any, recovering from a panic.
+func (t *PresenceTracker) notify(handler MemberHandler, who PresenceInfo) {
+	if handler != nil {
+		t.events.Go(func() {
+			defer t.client.recoverHandler("emitter/presence/", nil)
+			handler(t, who)
+		})
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+// DiffPresence compares two status snapshots of a channel and returns a subscribe event
+// for each client which joined and an unsubscribe event for each client which left, in
+// that order and by identifier. The events carry the channel and the time of the latest
+// snapshot; a nil snapshot is empty.
+func DiffPresence(before, after *PresenceEvent) []PresenceEvent {
+	var prev, next []PresenceInfo
+	if before != nil {
+		prev = before.Who
+	}
+
+	ev := PresenceEvent{}
+	if after != nil {
+		next = after.Who
+		ev.Channel = after.Channel
+		ev.Time = after.Time
+	}
+
+	joined := missing(next, prev)
+	left := missing(prev, next)
+	changes := make([]PresenceEvent, 0, len(joined)+len(left))
+	for _, who := range joined {
+		ev.Event, ev.Who = PresenceSubscribe, []PresenceInfo{who}
+		changes = append(changes, ev)
+	}
+	for _, who := range left {
+		ev.Event, ev.Who = PresenceUnsubscribe, []PresenceInfo{who}
+		changes = append(changes, ev)
+	}
+	return changes
+}
+
+// missing returns the clients of a list which the other list does not contain, ordered
+// by identifier.
+func missing(list, other []PresenceInfo) []PresenceInfo {
+	ids := make(map[string]struct{}, len(other))
+	for _, who := range other {
+		ids[who.ID] = struct{}{}
+	}
+
+	var result []PresenceInfo
+	for _, who := range list {
+		if _, ok := ids[who.ID]; !ok {
+			ids[who.ID] = struct{}{} // Only once
+			result = append(result, who)
+		}
+	}
+
+	sortByID(result)
+	return result
+}
+
+// sortByID sorts a list of clients by identifier.
+func sortByID(list []PresenceInfo) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+}
+
+// ------------------------------------------------------------------------------------
+
+// PresenceSubscription represents a presence handler subscribed to a channel.
+type PresenceSubscription struct {
+	id       uint64      // The identifier of the handler route
+	client   *Client     // The client which subscribed
+	key      string      // The key used to request the presence
+	channel  string      // The channel subscribed to, as normalized by the broker
+	wildcard bool        // Whether the channel contains a wildcard
+	closed   atomic.Bool // Whether the handler was unsubscribed
+}
+
+// SubscribePresence subscribes a handler to the presence change events of a channel.
+func (c *Client) SubscribePresence(key, channel string, handler PresenceHandler) (*PresenceSubscription, error) {
+	return c.SubscribePresenceContext(context.Background(), key, channel, handler)
+}
+
+// SubscribePresenceContext subscribes a handler to the presence change events of a
+// channel and waits for the broker to acknowledge it until the context is done. The
+// handler receives the events of the channel and of its sub-channels, and the channel
+// may contain wildcards, in which case the change events are not requested from the
+// broker but must be requested for each channel, for example with Presence. The events
+// which no handler matches are passed to the handler set with OnPresence.
+func (c *Client) SubscribePresenceContext(ctx context.Context, key, channel string, handler PresenceHandler) (*PresenceSubscription, error) {
+	ch := NewChannel(channel)
+	if err := ch.Validate(); err != nil {
+		return nil, err
+	}
+
+	s := &PresenceSubscription{
+		client:   c,
+		key:      key,
+		channel:  ch.Name(),
+		wildcard: ch.IsWildcard(),
+	}
+
+	s.id = c.watches.routes.Add(s.channel, func(c *Client, m Message) {
+		handler(c, m.(*presenceMessage).event)
+	})
+
+	c.watches.subs.Add(s)
+	if err := s.enable(ctx); err != nil {
+		c.watches.routes.Remove(s.channel, s.id)
+		c.watches.subs.Remove(s)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Channel returns the channel subscribed to.
+func (s *PresenceSubscription) Channel() string {
+	return s.channel
+}
+
+// Close removes the handler of the subscription.
+func (s *PresenceSubscription) Close() error {
+	return s.CloseContext(context.Background())
+}
+
+// CloseContext removes the handler of the subscription. If no other handler or tracker
+// follows the channel, it disables the presence change events and waits for the request
+// to be delivered until the context is done.
+func (s *PresenceSubscription) CloseContext(ctx context.Context) error {
+	if !s.closed.CompareAndSwap(false, true) {
+		return nil // Already closed
+	}
+
+	c := s.client
+	c.watches.routes.Remove(s.channel, s.id)
+	c.watches.subs.Remove(s)
+	if s.wildcard || c.isWatching(s.channel) {
+		return nil
+	}
+
+	return c.disablePresence(ctx, s.key, s.channel)
+}
+
+// watching returns the channel subscribed to.
+func (s *PresenceSubscription) watching() string {
+	return s.channel
+}
+
+// enable requests the presence change events of the channel, unless it contains a
+// wildcard.
+func (s *PresenceSubscription) enable(ctx context.Context) error {
+	if s.wildcard {
+		return nil
+	}
+
+	_, err := s.client.PresenceContext(ctx, s.key, s.channel, true, true)
+	return err
+}
+
+// presenceMessage represents a presence message along with its decoded event, as
+// passed to the presence handler routes.
+type presenceMessage struct {
+	Message
+	event PresenceEvent
+}
+
+// ------------------------------------------------------------------------------------
+
+// watcher follows a channel, such as a presence tracker or a durable consumer.
+type watcher interface {
+	comparable
+	watching() string
+}
+
+// watchers keeps track of the presence trackers, presence subscriptions or durable
+// consumers of a client, which are restored once the client reconnects.
+type watchers[T watcher] struct {
+	sync.Mutex
+	items map[T]struct{}
+}
+
+// newWatchers creates a new presence watcher registry.
+func newWatchers[T watcher]() *watchers[T] {
+	return &watchers[T]{
+		items: make(map[T]struct{}),
+	}
+}
+
+// Add adds a watcher.
+func (r *watchers[T]) Add(w T) {
+	r.Lock()
+	defer r.Unlock()
+	r.items[w] = struct{}{}
+}
+
+// Remove removes a watcher and returns whether it was registered.
+func (r *watchers[T]) Remove(w T) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	_, ok := r.items[w]
+	delete(r.items, w)
+	return ok
+}
+
+// Watches checks whether a watcher follows a channel.
+func (r *watchers[T]) Watches(channel string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	for w := range r.items {
+		if w.watching() == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// All returns all of the watchers.
+func (r *watchers[T]) All() []T {
+	r.Lock()
+	defer r.Unlock()
+
+	all := make([]T, 0, len(r.items))
+	for w := range r.items {
+		all = append(all, w)
+	}
+	return all
+}
+
+// ------------------------------------------------------------------------------------
+
+// presenceRegistry keeps track of the presence handlers, subscriptions and trackers of
+// a client.
+type presenceRegistry struct {
+	routes   *trie                            // The presence handlers, by channel
+	subs     *watchers[*PresenceSubscription] // The presence subscriptions
+	trackers *watchers[*PresenceTracker]      // The presence trackers
+}
+
+// newPresenceRegistry creates a new presence registry.
+func newPresenceRegistry() *presenceRegistry {
+	return &presenceRegistry{
+		routes:   NewTrie(),
+		subs:     newWatchers[*PresenceSubscription](),
+		trackers: newWatchers[*PresenceTracker](),
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+// routePresence applies a presence change event to the trackers of its channel and calls
+// the presence handlers matching the channel, or the default presence handler if none
+// matches.
+func (c *Client) routePresence(ev PresenceEvent, m Message) {
+	for _, t := range c.watches.trackers.All() {
+		if t.channel == ev.Channel {
+			t.apply(ev)
+		}
+	}
+
+	handlers := c.watches.routes.Lookup(ev.Channel)
+	if len(handlers) == 0 {
+		if c.presence != nil {
+			c.invokePresence(ev, m)
+		}
+		return
+	}
+
+	pm := &presenceMessage{Message: m, event: ev}
+	for _, h := range handlers {
+		func() {
+			defer c.recoverHandler(m.Topic(), m.Payload())
+			h(c, pm)
+		}()
+	}
+}
+
+// isWatching checks whether a presence tracker or subscription follows a channel.
+func (c *Client) isWatching(channel string) bool {
+	return c.watches.trackers.Watches(channel) || c.watches.subs.Watches(channel)
+}
+
+// resyncPresence re-synchronizes every presence tracker and requests the change events
+// of every presence subscription again, since they are lost when the connection is
+// re-established.
+func (c *Client) resyncPresence() {
+	for _, t := range c.watches.trackers.All() {
+		ctx, cancel := c.withTimeout(context.Background())
+		err := t.sync(ctx)
+		cancel()
+
+		if err != nil {
+			c.logger.Warn("emitter: unable to re-synchronize a presence tracker",
+				slog.String("channel", t.channel),
+				slog.Any("error", err))
+		}
+	}
+
+	for _, s := range c.watches.subs.All() {
+		ctx, cancel := c.withTimeout(context.Background())
+		err := s.enable(ctx)
+		cancel()
+
+		if err != nil {
+			c.logger.Warn("emitter: unable to restore a presence subscription",
+				slog.String("channel", s.channel),
+				slog.Any("error", err))
+		}
+	}
+}
+
+// disablePresence sends a presence request which disables the change events of a
+// channel, and waits for the status the broker responds with.
+func (c *Client) disablePresence(ctx context.Context, key, channel string) error {
+	key, err := c.resolveKey(ctx, key, channel, PermPresence)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.request(ctx, "presence", &presenceRequest{Key: key, Channel: channel})
+	return err
+}
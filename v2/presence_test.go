@@ -0,0 +1,195 @@
+package emitter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresenceTracker(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	ctx := context.Background()
+	first, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer first.Disconnect(0)
+	assert.NoError(t, first.Subscribe("key", "roster/", nil))
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	// The tracker is seeded with the clients already subscribed
+	tracker, err := c.TrackPresence(ctx, "key", "roster")
+	assert.NoError(t, err)
+	assert.Equal(t, "roster/", tracker.Channel())
+	assert.Equal(t, 1, tracker.Count())
+	assert.True(t, tracker.IsOnline(first.ID()))
+
+	joined := make(chan string, 1)
+	left := make(chan string, 1)
+	tracker.OnJoin(func(_ *PresenceTracker, who PresenceInfo) { joined <- who.ID })
+	tracker.OnLeave(func(_ *PresenceTracker, who PresenceInfo) { left <- who.ID })
+
+	second, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer second.Disconnect(0)
+	assert.NoError(t, second.Subscribe("key", "roster/", nil))
+	assert.Equal(t, second.ID(), receive(t, joined))
+	assert.Equal(t, 2, tracker.Count())
+	assert.Len(t, tracker.Members(), 2)
+
+	assert.NoError(t, first.Unsubscribe("key", "roster/"))
+	assert.Equal(t, first.ID(), receive(t, left))
+	assert.False(t, tracker.IsOnline(first.ID()))
+	assert.Equal(t, []PresenceInfo{{ID: second.ID()}}, tracker.Members())
+
+	assert.NoError(t, tracker.Close())
+	assert.NoError(t, tracker.Close())
+}
+
+func TestPresenceTrackerRequests(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	ctx := context.Background()
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	tracker, err := c.TrackPresence(ctx, "key", "roster/")
+	assert.NoError(t, err)
+
+	// The join handler makes a request, which is answered while it runs
+	counts := make(chan string, 1)
+	tracker.OnJoin(func(t *PresenceTracker, _ PresenceInfo) {
+		status, err := c.PresenceContext(ctx, "key", t.Channel(), true, true)
+		if err != nil {
+			counts <- err.Error()
+			return
+		}
+		counts <- strconv.Itoa(len(status.Who))
+	})
+
+	other, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer other.Disconnect(0)
+	assert.NoError(t, other.Subscribe("key", "roster/", nil))
+	assert.Equal(t, "1", receive(t, counts))
+}
+
+func TestPresenceTrackerResync(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	connected := make(chan struct{}, 1)
+	c := NewClient(WithBrokers(srv.URL), WithAutoReconnect(true))
+	c.OnConnect(func(_ *Client) { connected <- struct{}{} })
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+	<-connected
+
+	tracker, err := c.TrackPresence(context.Background(), "key", "roster/")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, tracker.Count())
+
+	joined := make(chan string, 1)
+	tracker.OnJoin(func(_ *PresenceTracker, who PresenceInfo) { joined <- who.ID })
+
+	// The change events are requested again once reconnected
+	srv.CloseClientConnections()
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the reconnection")
+	}
+
+	other, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer other.Disconnect(0)
+	assert.NoError(t, other.Subscribe("key", "roster/", nil))
+	assert.Equal(t, other.ID(), receive(t, joined))
+}
+
+func TestPresenceTrackerWildcard(t *testing.T) {
+	_, err := NewClient().TrackPresence(context.Background(), "key", "roster/+/")
+	assert.True(t, errors.Is(err, ErrInvalidChannel))
+}
+
+func TestSubscribePresence(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	c, err := Connect(srv.URL, nil, WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	global := make(chan string, 1)
+	c.OnPresence(func(_ *Client, ev PresenceEvent) { global <- ev.Channel })
+
+	lobby := make(chan string, 1)
+	sub, err := c.SubscribePresence("key", "lobby", func(_ *Client, ev PresenceEvent) { lobby <- ev.Event.String() })
+	assert.NoError(t, err)
+	assert.Equal(t, "lobby/", sub.Channel())
+
+	// The change events of a wildcard must be requested for each channel
+	rooms := make(chan string, 1)
+	_, err = c.SubscribePresence("key", "rooms/+/", func(_ *Client, ev PresenceEvent) { rooms <- ev.Channel })
+	assert.NoError(t, err)
+	_, err = c.Presence("key", "rooms/a/", true, true)
+	assert.NoError(t, err)
+	_, err = c.Presence("key", "other/", true, true)
+	assert.NoError(t, err)
+
+	other, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer other.Disconnect(0)
+
+	assert.NoError(t, other.Subscribe("key", "lobby/", nil))
+	assert.Equal(t, "subscribe", receive(t, lobby))
+	assert.NoError(t, other.Subscribe("key", "rooms/a/", nil))
+	assert.Equal(t, "rooms/a/", receive(t, rooms))
+	assert.NoError(t, other.Subscribe("key", "other/", nil))
+	assert.Equal(t, "other/", receive(t, global))
+
+	// Once closed, the change events of the channel are disabled
+	assert.NoError(t, sub.Close())
+	assert.NoError(t, other.Unsubscribe("key", "lobby/"))
+	assert.NoError(t, other.Unsubscribe("key", "other/"))
+	assert.Equal(t, "other/", receive(t, global))
+	assert.Empty(t, lobby)
+
+	// The status responding to the request was awaited
+	assert.Equal(t, 0, c.RequestStats().Outstanding)
+	assert.NotContains(t, logs.String(), "does not match any pending request")
+}
+
+func TestDiffPresence(t *testing.T) {
+	before := &PresenceEvent{Who: []PresenceInfo{{ID: "A"}, {ID: "B"}, {ID: "C"}}}
+	after := &PresenceEvent{
+		Channel: "roster/",
+		Time:    time.Unix(1589626821, 0),
+		Who:     []PresenceInfo{{ID: "D"}, {ID: "B"}, {ID: "A"}, {ID: "E"}},
+	}
+
+	var changes []string
+	for _, ev := range DiffPresence(before, after) {
+		assert.Equal(t, "roster/", ev.Channel)
+		assert.Equal(t, after.Time, ev.Time)
+		changes = append(changes, ev.Event.String()+" "+ev.Who[0].ID)
+	}
+	assert.Equal(t, []string{"subscribe D", "subscribe E", "unsubscribe C"}, changes)
+
+	assert.Len(t, DiffPresence(nil, after), 4)
+	assert.Len(t, DiffPresence(before, nil), 3)
+	assert.Empty(t, DiffPresence(after, after))
+}
@@ -0,0 +1,157 @@
+package emitter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// subscription represents an active subscription, which is re-issued when the client
+// reconnects to the broker.
+type subscription struct {
+	Key     string         // The key used to subscribe
+	Channel string         // The channel subscribed to
+	Group   string         // The share group, if any
+	Options []Option       // The options of the subscription
+	Handler MessageHandler // The handler registered along with the subscription
+	Link    *linkRequest   // The link request, for subscriptions made through a link
+}
+
+// topic formats the topic of the subscription, optionally without the options which
+// request the message history.
+func (s *subscription) topic(withHistory bool) string {
+	options := s.Options
+	if !withHistory {
+		options = withoutHistory(options)
+	}
+
+	return s.channel().WithOptions(options...).String()
+}
+
+// channel returns the channel of the subscription, without the options.
+func (s *subscription) channel() Channel {
+	return NewChannel(s.Channel).WithKey(s.Key).WithGroup(s.Group)
+}
+
+// String returns a human-readable representation of the subscription.
+func (s *subscription) String() string {
+	if s.Link != nil {
+		return fmt.Sprintf("link %s (%s)", s.Link.Name, s.Link.Channel)
+	}
+	return s.topic(false)
+}
+
+// withoutHistory removes the options which request the message history.
+func withoutHistory(options []Option) []Option {
+	filtered := make([]Option, 0, len(options))
+	for _, o := range options {
+		if !strings.HasPrefix(o.String(), "last=") {
+			filtered = append(filtered, o)
+		}
+	}
+	return filtered
+}
+
+// ------------------------------------------------------------------------------------
+
+// registry keeps track of the active subscriptions of a client, along with the number
+// of handles which share each of them.
+type registry struct {
+	sync.Mutex
+	subs map[string]*subscription
+	refs map[string]int
+}
+
+// newRegistry creates a new subscription registry.
+func newRegistry() *registry {
+	return &registry{
+		subs: make(map[string]*subscription),
+		refs: make(map[string]int),
+	}
+}
+
+// Add adds or replaces a subscription, and adds a reference to it.
+func (r *registry) Add(s *subscription) {
+	r.Lock()
+	defer r.Unlock()
+
+	key := registryKey(s)
+	r.subs[key] = s
+	r.refs[key]++
+}
+
+// Release removes a reference to a subscription, and removes the subscription once
+// no reference is left. It returns whether the subscription was removed.
+func (r *registry) Release(s *subscription) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	key := registryKey(s)
+	if _, ok := r.subs[key]; !ok {
+		return false
+	}
+
+	if r.refs[key]--; r.refs[key] > 0 {
+		return false
+	}
+
+	delete(r.subs, key)
+	delete(r.refs, key)
+	return true
+}
+
+// Remove removes a subscription, regardless of its references.
+func (r *registry) Remove(s *subscription) {
+	r.Lock()
+	defer r.Unlock()
+
+	key := registryKey(s)
+	delete(r.subs, key)
+	delete(r.refs, key)
+}
+
+// RemoveLinks removes the subscriptions made through a link to a channel.
+func (r *registry) RemoveLinks(channel string) {
+	r.Lock()
+	defer r.Unlock()
+
+	name := NewChannel(channel).Name()
+	for key, s := range r.subs {
+		if s.Link != nil && NewChannel(s.Channel).Name() == name {
+			delete(r.subs, key)
+			delete(r.refs, key)
+		}
+	}
+}
+
+// Len returns the number of active subscriptions.
+func (r *registry) Len() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.subs)
+}
+
+// All returns all of the active subscriptions.
+func (r *registry) All() []*subscription {
+	r.Lock()
+	defer r.Unlock()
+
+	subs := make([]*subscription, 0, len(r.subs))
+	for _, s := range r.subs {
+		subs = append(subs, s)
+	}
+	return subs
+}
+
+// registryKey returns the key of a subscription in the registry, which ignores the
+// options so that subscribing again to a channel replaces the subscription.
+func registryKey(s *subscription) string {
+	switch {
+	case s.Link != nil:
+		return "link:" + s.Link.Name
+	case s.Group != "":
+		return formatShare(s.Key, s.Group, s.Channel, nil)
+	default:
+		return formatTopic(s.Key, s.Channel, nil)
+	}
+}
@@ -0,0 +1,45 @@
+package emitter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionTopic(t *testing.T) {
+	sub := &subscription{Key: "key", Channel: "a/#/", Options: []Option{WithoutEcho(), WithLast(10)}}
+	assert.Equal(t, "key/a/#?me=0&last=10", sub.topic(true))
+	assert.Equal(t, "key/a/#?me=0", sub.topic(false))
+
+	sub = &subscription{Key: "key", Channel: "a/", Group: "g", Options: []Option{WithLast(10)}}
+	assert.Equal(t, "key/$share/g/a/?last=10", sub.topic(true))
+	assert.Equal(t, "key/$share/g/a/", sub.topic(false))
+}
+
+func TestRegistry(t *testing.T) {
+	r := newRegistry()
+	r.Add(&subscription{Key: "key", Channel: "a/"})
+	r.Add(&subscription{Key: "key", Channel: "a/", Options: []Option{WithLast(5)}})
+	r.Add(&subscription{Key: "key", Channel: "a/", Group: "g"})
+	r.Add(&subscription{Key: "key", Channel: "b/", Link: &linkRequest{Name: "x"}})
+	assert.Len(t, r.All(), 3)
+
+	r.Remove(&subscription{Key: "key", Channel: "/a/"})
+	assert.Len(t, r.All(), 2)
+
+	r.RemoveLinks("a/")
+	assert.Len(t, r.All(), 2)
+	r.RemoveLinks("/b")
+	assert.Len(t, r.All(), 1)
+}
+
+func TestRegistryRelease(t *testing.T) {
+	r := newRegistry()
+	r.Add(&subscription{Key: "key", Channel: "a/"})
+	r.Add(&subscription{Key: "key", Channel: "a/"})
+	assert.False(t, r.Release(&subscription{Key: "key", Channel: "a/"}))
+	assert.Equal(t, 1, r.Len())
+	assert.True(t, r.Release(&subscription{Key: "key", Channel: "a/"}))
+	assert.Equal(t, 0, r.Len())
+	assert.False(t, r.Release(&subscription{Key: "key", Channel: "a/"}))
+}
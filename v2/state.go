@@ -0,0 +1,222 @@
+package emitter
+
+import (
+	"sync"
+	"time"
+)
+
+// State represents the state of the connection to the broker.
+type State uint8
+
+// Various connection states
+const (
+	Disconnected State = iota // The client is not connected and will not reconnect
+	Connecting                // The client is establishing the initial connection
+	Connected                 // The client is connected to a broker
+	Reconnecting              // The connection was lost and the client is reconnecting
+	Closed                    // The client was disconnected by the application
+)
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	case Closed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// Status represents the current state of the connection along with the details of
+// the last transition.
+type Status struct {
+	State   State     // The current state
+	Since   time.Time // The time of the last transition
+	Broker  string    // The broker connected or being connected to
+	Err     error     // The error which caused the last transition, if any
+	LastErr error     // The last connection error, kept once reconnected
+}
+
+// StateChange represents a transition of the connection state.
+type StateChange struct {
+	From   State     // The previous state
+	To     State     // The new state
+	Time   time.Time // The time of the transition
+	Broker string    // The broker connected or being connected to
+	Err    error     // The error which caused the transition, if any
+}
+
+// StateHandler is a callback that is called whenever the state of the connection
+// changes.
+type StateHandler func(*Client, StateChange)
+
+// ------------------------------------------------------------------------------------
+
+// machine keeps track of the connection state.
+type machine struct {
+	sync.Mutex
+	status  Status        // The current status
+	notify  sync.Mutex    // Serializes the transitions along with their notifications
+	events  serial        // Delivers the notifications in order
+	closed  chan struct{} // Closed once the client is closed, nil until requested
+	handler StateHandler  // User-defined state handler
+}
+
+// transition moves to a new state and returns the transition, unless the state is
+// unchanged. Once closed, only an explicit connection moves out of the closed state.
+func (m *machine) transition(to State, err error) (StateChange, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	// The error is recorded even if the state is unchanged, as the connection may be
+	// reported lost once the reconnection started
+	if err != nil {
+		m.status.LastErr = err
+	}
+
+	from := m.status.State
+	if from == to || (from == Closed && to != Connecting) {
+		return StateChange{}, false
+	}
+
+	switch {
+	case to == Closed:
+		close(m.done())
+	case from == Closed:
+		m.closed = nil
+	}
+
+	now := time.Now()
+	m.status.State = to
+	m.status.Since = now
+	m.status.Err = err
+	return StateChange{
+		From:   from,
+		To:     to,
+		Time:   now,
+		Broker: m.status.Broker,
+		Err:    err,
+	}, true
+}
+
+// done returns a channel which is closed once the client is closed by the application.
+// The machine must be locked.
+func (m *machine) done() chan struct{} {
+	if m.closed == nil {
+		m.closed = make(chan struct{})
+	}
+	return m.closed
+}
+
+// Closed returns a channel which is closed once the client is closed by the application.
+func (m *machine) Closed() <-chan struct{} {
+	m.Lock()
+	defer m.Unlock()
+	return m.done()
+}
+
+// setBroker records the broker being connected to.
+func (m *machine) setBroker(broker string) {
+	m.Lock()
+	defer m.Unlock()
+	m.status.Broker = broker
+}
+
+// current returns the current status.
+func (m *machine) current() Status {
+	m.Lock()
+	defer m.Unlock()
+	return m.status
+}
+
+// ------------------------------------------------------------------------------------
+
+// State returns the current state of the connection.
+func (c *Client) State() State {
+	return c.state.current().State
+}
+
+// Status returns the current state of the connection, the time of the last transition,
+// the broker connected or being connected to, the error which caused the transition and
+// the last connection error, which remains once reconnected.
+func (c *Client) Status() Status {
+	return c.state.current()
+}
+
+// OnStateChange sets the function that will be called whenever the state of the
+// connection changes. The changes are notified in order on a separate goroutine, so the
+// handler may connect or disconnect the client.
+func (c *Client) OnStateChange(handler StateHandler) {
+	c.state.Lock()
+	defer c.state.Unlock()
+	c.state.handler = handler
+}
+
+// setState moves the connection to a new state and queues the notification of the state
+// handler, which runs outside of the locks held by the caller.
+func (c *Client) setState(to State, err error) {
+	c.state.notify.Lock()
+	defer c.state.notify.Unlock()
+
+	change, ok := c.state.transition(to, err)
+	if !ok {
+		return
+	}
+
+	c.state.Lock()
+	handler := c.state.handler
+	c.state.Unlock()
+	if handler != nil {
+		c.state.events.Go(func() {
+			handler(c, change)
+		})
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+// serial runs functions one at a time, in the order they were queued, on a goroutine
+// started on demand. This lets callbacks run outside of the locks held when queued.
+type serial struct {
+	sync.Mutex
+	queue   []func() // The functions waiting to run
+	running bool     // Whether a goroutine is running the queue
+}
+
+// Go queues a function, which runs once the functions queued before have returned.
+func (s *serial) Go(fn func()) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.queue = append(s.queue, fn)
+	if !s.running {
+		s.running = true
+		go s.drain()
+	}
+}
+
+// drain runs the queued functions until none is left.
+func (s *serial) drain() {
+	for {
+		s.Lock()
+		if len(s.queue) == 0 {
+			s.running = false
+			s.Unlock()
+			return
+		}
+
+		fn := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.Unlock()
+		fn()
+	}
+}
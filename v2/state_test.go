@@ -0,0 +1,147 @@
+package emitter
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateString(t *testing.T) {
+	assert.Equal(t, "disconnected", Disconnected.String())
+	assert.Equal(t, "connecting", Connecting.String())
+	assert.Equal(t, "connected", Connected.String())
+	assert.Equal(t, "reconnecting", Reconnecting.String())
+	assert.Equal(t, "closed", Closed.String())
+	assert.Equal(t, "unknown", State(42).String())
+}
+
+func TestStateTransition(t *testing.T) {
+	var m machine
+	_, ok := m.transition(Disconnected, nil)
+	assert.False(t, ok)
+
+	m.setBroker("tcp://localhost:8080")
+	change, ok := m.transition(Reconnecting, errors.New("boom"))
+	assert.True(t, ok)
+	assert.Equal(t, Disconnected, change.From)
+	assert.Equal(t, Reconnecting, change.To)
+	assert.Equal(t, "tcp://localhost:8080", change.Broker)
+	assert.EqualError(t, m.current().Err, "boom")
+
+	// The last error remains once the transition succeeded
+	_, ok = m.transition(Reconnecting, errors.New("lost"))
+	assert.False(t, ok)
+	_, ok = m.transition(Connected, nil)
+	assert.True(t, ok)
+	assert.NoError(t, m.current().Err)
+	assert.EqualError(t, m.current().LastErr, "lost")
+
+	// Once closed, only a new connection leaves the closed state
+	_, ok = m.transition(Closed, nil)
+	assert.True(t, ok)
+	_, ok = m.transition(Reconnecting, nil)
+	assert.False(t, ok)
+	_, ok = m.transition(Connecting, nil)
+	assert.True(t, ok)
+}
+
+func TestStateChanges(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var states []State
+	c := NewClient(WithBrokers(srv.URL))
+	c.OnStateChange(func(_ *Client, change StateChange) {
+		mu.Lock()
+		defer mu.Unlock()
+		states = append(states, change.To)
+	})
+
+	assert.Equal(t, Disconnected, c.State())
+	assert.NoError(t, c.Connect())
+	assert.Equal(t, Connected, c.State())
+	assert.Equal(t, srv.URL, c.Status().Broker)
+
+	// Drop the connection and wait for the automatic reconnection
+	srv.CloseClientConnections()
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(states) >= 4 && states[len(states)-1] == Connected
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The error which caused the reconnection remains visible
+	assert.Eventually(t, func() bool { return c.Status().LastErr != nil }, time.Second, 10*time.Millisecond)
+
+	c.Disconnect(0)
+	status := c.Status()
+	assert.Equal(t, Closed, status.State)
+	assert.WithinDuration(t, time.Now(), status.Since, time.Second)
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(states) == 5
+	}, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []State{Connecting, Connected, Reconnecting, Connected, Closed}, states)
+}
+
+func TestStateHandlerReentrant(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	// The handler disconnects the client as soon as it is connected
+	closed := make(chan struct{})
+	c := NewClient(WithBrokers(srv.URL))
+	c.OnStateChange(func(c *Client, change StateChange) {
+		switch change.To {
+		case Connected:
+			c.Disconnect(0)
+		case Closed:
+			close(closed)
+		}
+	})
+
+	assert.NoError(t, c.Connect())
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the disconnection")
+	}
+}
+
+func TestSerial(t *testing.T) {
+	var s serial
+	var out []int
+	done := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		s.Go(func() {
+			out = append(out, i)
+		})
+	}
+	s.Go(func() { close(done) })
+
+	<-done
+	assert.Len(t, out, 100)
+	for i, v := range out {
+		assert.Equal(t, i, v)
+	}
+}
+
+func TestStateConnectFailure(t *testing.T) {
+	c := NewClient(WithBrokers("tcp://127.0.0.1:1"), WithConnectTimeout(time.Second))
+	assert.Error(t, c.Connect())
+
+	status := c.Status()
+	assert.Equal(t, Disconnected, status.State)
+	assert.Error(t, status.Err)
+	assert.Equal(t, status.Err, status.LastErr)
+}
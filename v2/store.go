@@ -1,7 +1,6 @@
 package emitter
 
 import (
-	"fmt"
 	"sync"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
@@ -10,7 +9,7 @@ import (
 // In-memory storage implementation
 type store struct {
 	sync.RWMutex
-	messages map[string]*packet
+	messages map[string]packets.ControlPacket
 }
 
 // Response represents a generic response sent by the broker.
@@ -18,16 +17,10 @@ type Response interface {
 	RequestID() uint16
 }
 
-// The control packet with an optional error and a response.
-type packet struct {
-	packets.ControlPacket
-	callback chan Response
-}
-
 // newStore creates a new message storage layer.
-func newErrorStore() *store {
+func newStore() *store {
 	store := &store{
-		messages: make(map[string]*packet),
+		messages: make(map[string]packets.ControlPacket),
 	}
 	return store
 }
@@ -39,10 +32,7 @@ func (store *store) Open() {}
 func (store *store) Put(key string, message packets.ControlPacket) {
 	store.Lock()
 	defer store.Unlock()
-
-	store.messages[key] = &packet{
-		ControlPacket: message,
-	}
+	store.messages[key] = message
 }
 
 // Get takes a key and looks in the store for a matching Message
@@ -71,11 +61,7 @@ func (store *store) All() []string {
 func (store *store) Del(key string) {
 	store.Lock()
 	defer store.Unlock()
-
-	m := store.messages[key]
-	if m != nil && m.callback == nil {
-		delete(store.messages, key)
-	}
+	delete(store.messages, key)
 }
 
 // Close will disallow modifications to the state of the store.
@@ -85,38 +71,5 @@ func (store *store) Close() {}
 func (store *store) Reset() {
 	store.Lock()
 	defer store.Unlock()
-	store.messages = make(map[string]*packet)
-}
-
-// PutCallback adds a callback channel to a message.
-func (store *store) PutCallback(id uint16) <-chan Response {
-	store.Lock()
-	defer store.Unlock()
-
-	key := outboundKeyFromMID(id)
-	if m, ok := store.messages[key]; ok && m != nil {
-		m.callback = make(chan Response, 1)
-		return m.callback
-	}
-	return nil
-}
-
-// NotifyResponse notifies a response on a callback (if exists)
-func (store *store) NotifyResponse(id uint16, response Response) bool {
-	store.RLock()
-	defer store.RUnlock()
-
-	key := outboundKeyFromMID(id)
-	if m, ok := store.messages[key]; ok && m != nil {
-		m.callback <- response
-		close(m.callback)
-		delete(store.messages, key)
-		return true
-	}
-	return false
-}
-
-// Return a string of the form "o.[id]"
-func outboundKeyFromMID(id uint16) string {
-	return fmt.Sprintf("o.%d", id)
+	store.messages = make(map[string]packets.ControlPacket)
 }
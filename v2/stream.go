@@ -0,0 +1,128 @@
+package emitter
+
+import (
+	"context"
+	"iter"
+	"sync"
+)
+
+// The capacity of the buffer of the sequences returned by Messages.
+const messagesBuffer = 64
+
+// mailbox buffers the messages of a subscription consumed through a Go channel.
+type mailbox struct {
+	sync.RWMutex
+	ch     chan Message    // The buffered messages
+	policy OverflowPolicy  // The policy to apply when the buffer is full
+	done   <-chan struct{} // Closed once the consumer is gone
+	closed bool            // Whether the channel is closed
+}
+
+// push adds a message to the buffer, applying the overflow policy when it is full.
+func (b *mailbox) push(m Message) {
+	b.RLock()
+	defer b.RUnlock()
+	if b.closed {
+		return
+	}
+
+	switch b.policy {
+	case DropNewest:
+		select {
+		case b.ch <- m:
+		default:
+		}
+	case DropOldest:
+		for {
+			select {
+			case b.ch <- m:
+				return
+			default:
+			}
+
+			// Make some room, unless the consumer already did
+			select {
+			case <-b.ch:
+			default:
+			}
+		}
+	default:
+		select {
+		case b.ch <- m:
+		case <-b.done:
+		}
+	}
+}
+
+// close closes the channel, once no message is being pushed.
+func (b *mailbox) close() {
+	b.Lock()
+	defer b.Unlock()
+	b.closed = true
+	close(b.ch)
+}
+
+// SubscribeChan subscribes to a channel and returns a Go channel receiving its messages,
+// buffering up to buf of them. When the buffer is full, the handler waits for the
+// consumer by default, which holds back the other messages; use WithOverflow to drop
+// messages instead. Once the context is done, the client unsubscribes and the Go
+// channel is closed.
+func (c *Client) SubscribeChan(ctx context.Context, key, channel string, buf int, options ...Option) (<-chan Message, error) {
+	box := &mailbox{
+		ch:     make(chan Message, buf),
+		policy: getOverflow(options),
+		done:   ctx.Done(),
+	}
+
+	sub, err := c.SubscribeHandle(ctx, key, channel, func(_ *Client, m Message) {
+		box.push(m)
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		unsubCtx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+
+		sub.UnsubscribeContext(unsubCtx)
+		box.close()
+	}()
+	return box.ch, nil
+}
+
+// Messages returns a sequence of the messages of a channel, which subscribes when the
+// iteration starts and unsubscribes when it stops or the context is done. A failure to
+// subscribe is yielded as an error. The backpressure is the same as SubscribeChan.
+func (c *Client) Messages(ctx context.Context, key, channel string, options ...Option) iter.Seq2[Message, error] {
+	return func(yield func(Message, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		messages, err := c.SubscribeChan(ctx, key, channel, messagesBuffer, options...)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for m := range messages {
+			if !yield(m, nil) {
+				return
+			}
+		}
+	}
+}
+
+// getOverflow gets the overflow policy from the options, Block by default.
+func getOverflow(options []Option) OverflowPolicy {
+	for _, o := range options {
+		switch o {
+		case withDropNewest:
+			return DropNewest
+		case withDropOldest:
+			return DropOldest
+		}
+	}
+	return Block
+}
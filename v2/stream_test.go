@@ -0,0 +1,97 @@
+package emitter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribeChan(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, err := c.SubscribeChan(ctx, "key", "a/", 10)
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Publish("key", "a/", "hello"))
+	select {
+	case m := <-messages:
+		assert.Equal(t, "hello", string(m.Payload()))
+	case <-time.After(time.Second):
+		assert.Fail(t, "the message was not received")
+	}
+
+	// Once the context is done, the channel is closed and unsubscribed
+	cancel()
+	for range messages {
+	}
+	assert.Len(t, c.subs.All(), 0)
+}
+
+func TestMessages(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	go func() {
+		for c.subs.Len() == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		for _, p := range []string{"1", "2", "3"} {
+			c.Publish("key", "a/", p)
+		}
+	}()
+
+	var received []string
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for m, err := range c.Messages(ctx, "key", "a/") {
+		assert.NoError(t, err)
+		if received = append(received, string(m.Payload())); len(received) == 2 {
+			break
+		}
+	}
+
+	assert.Equal(t, []string{"1", "2"}, received)
+	assert.Eventually(t, func() bool { return c.subs.Len() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestMailboxOverflow(t *testing.T) {
+	done := make(chan struct{})
+	for _, tc := range []struct {
+		option   Option
+		expected []string
+	}{
+		{option: WithOverflow(DropNewest), expected: []string{"1", "2"}},
+		{option: WithOverflow(DropOldest), expected: []string{"2", "3"}},
+	} {
+		box := &mailbox{ch: make(chan Message, 2), policy: getOverflow([]Option{tc.option}), done: done}
+		for _, p := range []string{"1", "2", "3"} {
+			box.push(&message{topic: "a/", payload: p})
+		}
+		box.close()
+
+		var received []string
+		for m := range box.ch {
+			received = append(received, string(m.Payload()))
+		}
+		assert.Equal(t, tc.expected, received)
+	}
+
+	// A blocked push gives up once the consumer is gone
+	box := &mailbox{ch: make(chan Message), policy: getOverflow(nil), done: done}
+	close(done)
+	box.push(&message{topic: "a/"})
+	assert.Equal(t, Block, box.policy)
+}
@@ -0,0 +1,146 @@
+package emitter
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Subscription represents a handler subscribed to a channel. The handlers subscribed to
+// the same channel are independent, and the channel is unsubscribed from the broker
+// once all of them are unsubscribed.
+type Subscription struct {
+	id       uint64        // The identifier of the handler route
+	client   *Client       // The client which subscribed
+	sub      *subscription // The subscription shared by the handles of the channel
+	received atomic.Uint64 // The number of messages received
+	last     atomic.Int64  // The time of the last message received, in unix nanoseconds
+	closed   atomic.Bool   // Whether the handle was unsubscribed
+}
+
+// SubscriptionStats represents the counters of a subscription.
+type SubscriptionStats struct {
+	Received    uint64    // The number of messages received
+	LastMessage time.Time // The time of the last message received, zero if none
+}
+
+// ID returns the identifier of the subscription, unique within the client.
+func (s *Subscription) ID() uint64 {
+	return s.id
+}
+
+// Key returns the key used to subscribe.
+func (s *Subscription) Key() string {
+	return s.sub.Key
+}
+
+// Channel returns the channel subscribed to.
+func (s *Subscription) Channel() string {
+	return s.sub.Channel
+}
+
+// Group returns the share group, if any.
+func (s *Subscription) Group() string {
+	return s.sub.Group
+}
+
+// Stats returns the counters of the subscription.
+func (s *Subscription) Stats() SubscriptionStats {
+	stats := SubscriptionStats{Received: s.received.Load()}
+	if last := s.last.Load(); last > 0 {
+		stats.LastMessage = time.Unix(0, last)
+	}
+	return stats
+}
+
+// Unsubscribe removes the handler of the subscription.
+func (s *Subscription) Unsubscribe() error {
+	return s.UnsubscribeContext(context.Background())
+}
+
+// UnsubscribeContext removes the handler of the subscription. If no other handler is
+// subscribed to the channel, it unsubscribes from the broker and waits for the broker
+// to acknowledge it until the context is done.
+func (s *Subscription) UnsubscribeContext(ctx context.Context) error {
+	if !s.closed.CompareAndSwap(false, true) {
+		return nil // Already unsubscribed
+	}
+
+	c := s.client
+	c.handlers.Remove(s.sub.Channel, s.id)
+	if !c.subs.Release(s.sub) {
+		return nil
+	}
+
+	c.metrics.Set(MetricSubscriptions, float64(c.subs.Len()))
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	key, err := c.resolveKey(ctx, s.sub.Key, s.sub.Channel, permissionsFor(PermRead, s.sub.Options))
+	if err != nil {
+		return err
+	}
+
+	topic := formatTopic(key, s.sub.Channel, nil)
+	if s.sub.Group != "" {
+		topic = formatShare(key, s.sub.Group, s.sub.Channel, nil)
+	}
+
+	err = c.do(ctx, c.conn.Unsubscribe(topic))
+	c.measure("unsubscribe", err)
+	return err
+}
+
+// handler returns the handler of the route, which counts the messages before calling
+// the handler provided.
+func (s *Subscription) handler(handler MessageHandler) MessageHandler {
+	if handler == nil {
+		return nil // No route, the messages go to the default handler
+	}
+
+	return func(c *Client, m Message) {
+		s.received.Add(1)
+		s.last.Store(time.Now().UnixNano())
+		handler(c, m)
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+// subscribe subscribes a handler to a channel and returns its handle. The handler is
+// added alongside the other handlers of the channel or, if shared, replaces the handler
+// shared by the subscriptions made without a handle.
+func (c *Client) subscribe(ctx context.Context, sub *subscription, handler MessageHandler, shared bool) (*Subscription, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if err := sub.channel().WithOptions(sub.Options...).Validate(); err != nil {
+		return nil, err
+	}
+
+	topic, err := c.subscriptionTopic(ctx, sub, true)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Subscription{client: c, sub: sub}
+	switch {
+	case !shared:
+		s.id = c.handlers.Add(sub.Channel, s.handler(handler))
+	case handler != nil:
+		c.handlers.AddHandler(sub.Channel, s.handler(handler))
+	}
+
+	// Issue subscribe and keep track of it, so it can be restored on reconnect
+	token := c.conn.Subscribe(topic, 0, nil)
+	if err := c.do(ctx, token); err != nil {
+		if !shared {
+			c.handlers.Remove(sub.Channel, s.id)
+		}
+		c.measure("subscribe", err)
+		return nil, err
+	}
+
+	c.addSubscription(sub)
+	return s, nil
+}
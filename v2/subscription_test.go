@@ -0,0 +1,101 @@
+package emitter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionHandles(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	fallback := make(chan string, 10)
+	c, err := Connect(srv.URL, func(_ *Client, m Message) {
+		fallback <- string(m.Payload())
+	})
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	ctx := context.Background()
+	first, second := make(chan string, 10), make(chan string, 10)
+	s1, err := c.SubscribeHandle(ctx, "key", "a/", func(_ *Client, m Message) { first <- string(m.Payload()) })
+	assert.NoError(t, err)
+	s2, err := c.SubscribeHandle(ctx, "key", "a/", func(_ *Client, m Message) { second <- string(m.Payload()) })
+	assert.NoError(t, err)
+	assert.NotEqual(t, s1.ID(), s2.ID())
+	assert.Equal(t, "a/", s1.Channel())
+
+	// Both handlers receive the messages of the channel
+	assert.NoError(t, c.Publish("key", "a/", "1"))
+	assert.Equal(t, "1", receive(t, first))
+	assert.Equal(t, "1", receive(t, second))
+	assert.Equal(t, uint64(1), s1.Stats().Received)
+	assert.False(t, s1.Stats().LastMessage.IsZero())
+
+	// Removing a handler keeps the other one subscribed
+	assert.NoError(t, s1.Unsubscribe())
+	assert.NoError(t, s1.Unsubscribe())
+	assert.NoError(t, c.Publish("key", "a/", "2"))
+	assert.Equal(t, "2", receive(t, second))
+	assert.Len(t, first, 0)
+	assert.Len(t, c.subs.All(), 1)
+
+	// Removing the last handler unsubscribes from the broker
+	assert.NoError(t, s2.Unsubscribe())
+	assert.Len(t, c.subs.All(), 0)
+	assert.NoError(t, c.Publish("key", "a/", "3"))
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, second, 0)
+	assert.Len(t, fallback, 0)
+}
+
+func TestSubscribeReplaces(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	fallback := make(chan string, 10)
+	c, err := Connect(srv.URL, func(_ *Client, m Message) {
+		fallback <- string(m.Payload())
+	})
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	// Subscribing again without a handle replaces the handler
+	first, second := make(chan string, 10), make(chan string, 10)
+	assert.NoError(t, c.Subscribe("key", "a/", func(_ *Client, m Message) { first <- string(m.Payload()) }))
+	assert.NoError(t, c.Subscribe("key", "a/", func(_ *Client, m Message) { second <- string(m.Payload()) }))
+	assert.NoError(t, c.Publish("key", "a/", "1"))
+	assert.Equal(t, "1", receive(t, second))
+
+	// A handle without a handler does not call the default handler when others match
+	s, err := c.SubscribeHandle(context.Background(), "key", "a/", nil)
+	assert.NoError(t, err)
+	assert.NotZero(t, s.ID())
+	assert.NoError(t, c.Publish("key", "a/", "2"))
+	assert.Equal(t, "2", receive(t, second))
+
+	// Without any handler, the default handler receives the messages
+	assert.NoError(t, c.Subscribe("key", "b/", nil))
+	assert.NoError(t, c.Publish("key", "b/", "3"))
+	assert.Equal(t, "3", receive(t, fallback))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, first, 0)
+	assert.Len(t, second, 0)
+	assert.Len(t, fallback, 0)
+}
+
+// receive waits for a value to be received on the channel.
+func receive(t *testing.T, ch chan string) string {
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second):
+		assert.Fail(t, "nothing was received")
+		return ""
+	}
+}
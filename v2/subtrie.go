@@ -7,6 +7,7 @@ import (
 
 // route is a value associated with a subscription.
 type route struct {
+	ID     uint64
 	Topic  string
 	Action MessageHandler
 }
@@ -26,7 +27,7 @@ func newRoute(topic string, handler MessageHandler) ([]string, route) {
 
 type node struct {
 	word     string
-	routes   map[string]route
+	routes   map[uint64]route
 	parent   *node
 	children map[string]*node
 }
@@ -45,7 +46,8 @@ func (n *node) orphan() {
 // trie represents an efficient collection of subscriptions with lookup capability.
 type trie struct {
 	sync.RWMutex
-	root *node // The root node of the tree.
+	root   *node  // The root node of the tree.
+	next   uint64 // The identifier of the next route.
 	lookup func(query []string, result *[]MessageHandler, node *node)
 }
 
@@ -72,11 +74,36 @@ func NewTrieMQTT() *trie {
 	return t
 }
 
-// AddHandler adds a message handler to a topic.
+// AddHandler adds or replaces the message handler of a topic, which is shared by the
+// subscriptions made without a handle.
 func (t *trie) AddHandler(topic string, handler MessageHandler) error {
 	query, rt := newRoute(topic, handler)
 
 	t.Lock()
+	t.add(query, rt)
+	t.Unlock()
+	return nil
+}
+
+// Add adds a message handler to a topic, alongside the handlers already added, and
+// returns the identifier of the route. A nil handler adds no route, but still gets an
+// identifier.
+func (t *trie) Add(topic string, handler MessageHandler) uint64 {
+	query, rt := newRoute(topic, handler)
+
+	t.Lock()
+	t.next++
+	rt.ID = t.next
+	if handler != nil {
+		t.add(query, rt)
+	}
+	t.Unlock()
+	return rt.ID
+}
+
+// add adds a route to a topic, replacing the route with the same identifier. The trie
+// must be locked.
+func (t *trie) add(query []string, rt route) {
 	curr := t.root
 	for _, word := range query {
 		child, ok := curr.children[word]
@@ -84,7 +111,7 @@ func (t *trie) AddHandler(topic string, handler MessageHandler) error {
 			child = &node{
 				word:     word,
 				parent:   curr,
-				routes:   make(map[string]route),
+				routes:   make(map[uint64]route),
 				children: make(map[string]*node),
 			}
 			curr.children[word] = child
@@ -93,13 +120,21 @@ func (t *trie) AddHandler(topic string, handler MessageHandler) error {
 	}
 
 	// Add the handler
-	curr.routes[rt.Topic] = rt
-	t.Unlock()
-	return nil
+	curr.routes[rt.ID] = rt
 }
 
-// RemoveHandler removes a message handler from a topic.
+// RemoveHandler removes every message handler from a topic.
 func (t *trie) RemoveHandler(topic string) {
+	t.remove(topic, func(route) bool { return true })
+}
+
+// Remove removes a single message handler, by the identifier of its route.
+func (t *trie) Remove(topic string, id uint64) {
+	t.remove(topic, func(rt route) bool { return rt.ID == id })
+}
+
+// remove removes the routes of a topic matching the predicate.
+func (t *trie) remove(topic string, match func(route) bool) {
 	query, _ := newRoute(topic, nil)
 
 	t.Lock()
@@ -114,8 +149,12 @@ func (t *trie) RemoveHandler(topic string) {
 		curr = child
 	}
 
-	// Remove the route
-	delete(curr.routes, topic)
+	// Remove the routes
+	for id, rt := range curr.routes {
+		if match(rt) {
+			delete(curr.routes, id)
+		}
+	}
 
 	// Remove orphans
 	if len(curr.routes) == 0 && len(curr.children) == 0 {
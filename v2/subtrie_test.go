@@ -48,6 +48,27 @@ func TestTrieMatch(t *testing.T) {
 	}
 }
 
+func TestTrieRemove(t *testing.T) {
+	m := NewTrie()
+	id1 := m.Add("a/", func(*Client, Message) {})
+	id2 := m.Add("a/", func(*Client, Message) {})
+	m.Add("a/b/", func(*Client, Message) {})
+	assert.NotEqual(t, id1, id2)
+	assert.Len(t, m.Lookup("a/b/"), 3)
+
+	m.Remove("a/", id1)
+	assert.Len(t, m.Lookup("a/b/"), 2)
+
+	m.RemoveHandler("a/b/")
+	assert.Len(t, m.Lookup("a/b/"), 1)
+
+	// The shared handler is replaced, and a nil handler adds no route
+	m.AddHandler("a/", func(*Client, Message) {})
+	m.AddHandler("a/", func(*Client, Message) {})
+	assert.NotZero(t, m.Add("a/", nil))
+	assert.Len(t, m.Lookup("a/"), 2)
+}
+
 // Populates the trie with a set of strings
 func testPopulateWithStrings(m *trie, values []string) {
 	for _, s := range values {
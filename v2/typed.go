@@ -0,0 +1,71 @@
+package emitter
+
+import (
+	"context"
+	"fmt"
+)
+
+// TypedChannel publishes and receives values of a given type on a channel, encoded with
+// a codec.
+type TypedChannel[T any] struct {
+	client  *Client  // The client to use
+	key     string   // The key of the channel
+	channel string   // The channel
+	codec   Codec    // The codec of the payloads
+	options []Option // The options applied to every operation
+}
+
+// NewTypedChannel creates a channel of values encoded with the codec provided, or JSON
+// if nil. The options are applied to every publish and, except for those which only
+// apply to publishes such as WithTTL or WithRetain, to every subscribe.
+func NewTypedChannel[T any](c *Client, key, channel string, codec Codec, options ...Option) *TypedChannel[T] {
+	if codec == nil {
+		codec = JSON
+	}
+
+	return &TypedChannel[T]{
+		client:  c,
+		key:     key,
+		channel: channel,
+		codec:   codec,
+		options: options,
+	}
+}
+
+// Publish encodes a value and publishes it to the channel.
+func (tc *TypedChannel[T]) Publish(ctx context.Context, v T, options ...Option) error {
+	payload, err := tc.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return tc.client.PublishContext(ctx, tc.key, tc.channel, payload, tc.with(options)...)
+}
+
+// Subscribe subscribes to the channel and calls the handler with each value received.
+// The payloads which cannot be decoded are reported to the failure handler of the client.
+func (tc *TypedChannel[T]) Subscribe(ctx context.Context, handler func(T, Message), options ...Option) error {
+	return tc.client.SubscribeContext(ctx, tc.key, tc.channel, func(c *Client, m Message) {
+		var v T
+		if err := tc.codec.Unmarshal(m.Payload(), &v); err != nil {
+			c.fail(&HandlerError{
+				Topic:   m.Topic(),
+				Payload: m.Payload(),
+				Err:     fmt.Errorf("unable to decode the payload, %w", err),
+			})
+			return
+		}
+
+		handler(v, m)
+	}, subscribeOptions(tc.options, options)...)
+}
+
+// Unsubscribe ends the subscription to the channel.
+func (tc *TypedChannel[T]) Unsubscribe(ctx context.Context) error {
+	return tc.client.UnsubscribeContext(ctx, tc.key, tc.channel)
+}
+
+// with returns the options of the channel followed by the ones provided.
+func (tc *TypedChannel[T]) with(options []Option) []Option {
+	return append(append([]Option{}, tc.options...), options...)
+}
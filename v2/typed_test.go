@@ -0,0 +1,66 @@
+package emitter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emitter-io/go/v2/emittertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedChannel(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c := NewClient(WithBrokers(srv.URL))
+	failures := make(chan *HandlerError, 1)
+	c.OnHandlerFailure(func(_ *Client, e *HandlerError) { failures <- e })
+	assert.NoError(t, c.Connect())
+	defer c.Disconnect(0)
+
+	ctx := context.Background()
+	points := make(chan point, 1)
+	ch := NewTypedChannel[point](c, "key", "points/", nil)
+	assert.NoError(t, ch.Subscribe(ctx, func(p point, m Message) {
+		assert.Equal(t, "points/", m.Topic())
+		points <- p
+	}))
+
+	assert.NoError(t, ch.Publish(ctx, point{3, 4}))
+	select {
+	case p := <-points:
+		assert.Equal(t, point{3, 4}, p)
+	case <-time.After(time.Second):
+		assert.Fail(t, "the value was not received")
+	}
+
+	// A payload which cannot be decoded is reported to the failure handler
+	assert.NoError(t, c.Publish("key", "points/", "not json"))
+	select {
+	case e := <-failures:
+		assert.Equal(t, "points/", e.Topic)
+		assert.Contains(t, e.Error(), "unable to decode the payload")
+	case <-time.After(time.Second):
+		assert.Fail(t, "the failure was not reported")
+	}
+
+	assert.NoError(t, ch.Unsubscribe(ctx))
+}
+
+func TestTypedChannelOptions(t *testing.T) {
+	srv := emittertest.NewServer()
+	defer srv.Close()
+
+	c, err := Connect(srv.URL, nil)
+	assert.NoError(t, err)
+	defer c.Disconnect(0)
+
+	// The options which only apply to publishes are not subscribed with
+	ctx := context.Background()
+	ch := NewTypedChannel[point](c, "key", "points/", nil, WithTTL(60), WithRetain(), WithoutEcho())
+	assert.NoError(t, ch.Subscribe(ctx, func(point, Message) {}))
+	subs := c.subs.All()
+	assert.Len(t, subs, 1)
+	assert.Equal(t, "key/points/?me=0", subs[0].topic(true))
+}
@@ -4,9 +4,12 @@
 package emitter
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 // MessageHandler is a callback type which can be set to be
@@ -33,12 +36,34 @@ type DisconnectHandler func(*Client, error)
 // at initial connection and on reconnection
 type ConnectHandler func(*Client)
 
+// RestoreHandler is a callback that is called when some of the subscriptions
+// could not be restored after an automatic reconnect.
+type RestoreHandler func(*Client, []RestoreError)
+
+// ContextHandler is a callback type for the messages which reports the failure to
+// handle a message by returning an error. Use Handle to subscribe with it.
+type ContextHandler func(context.Context, *Client, Message) error
+
+// FailureHandler is a callback that is called when a message handler returned an
+// error or panicked.
+type FailureHandler func(*Client, *HandlerError)
+
 // Option represents a key/value pair that can be supplied to the publish/subscribe or unsubscribe
 // methods and provide ways to configure the operation.
 type Option interface {
 	String() string
 }
 
+// OverflowPolicy represents the behaviour to adopt when a bounded queue is full.
+type OverflowPolicy uint8
+
+// Various overflow policies
+const (
+	DropNewest OverflowPolicy = iota // Reject the newest item
+	DropOldest                       // Discard the oldest items to make room
+	Block                            // Wait until there is room, the persistent store rejects the item instead
+)
+
 // Error represents an event code which provides a more details.
 type Error struct {
 	Request uint16 `json:"req,omitempty"`
@@ -56,6 +81,44 @@ func (e *Error) RequestID() uint16 {
 	return e.Request
 }
 
+// HandlerError represents the failure of a handler to process a message.
+type HandlerError struct {
+	Topic   string      // The topic of the message
+	Payload []byte      // The payload of the message
+	Err     error       // The error returned by the handler, or describing the panic
+	Panic   interface{} // The value of the panic, if the handler panicked
+	Stack   []byte      // The stack of the goroutine which panicked
+}
+
+// Error returns the error message.
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("emitter: unable to handle the message on '%s', due to %s", e.Topic, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// RestoreError represents a subscription which could not be restored after
+// the client reconnected to the broker.
+type RestoreError struct {
+	Key     string // The key of the subscription
+	Channel string // The channel of the subscription
+	Group   string // The share group of the subscription, if any
+	Err     error  // The error which occurred while subscribing
+}
+
+// Error returns the error message.
+func (e RestoreError) Error() string {
+	return fmt.Sprintf("unable to restore the subscription to '%s', due to %s", e.Channel, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e RestoreError) Unwrap() error {
+	return e.Err
+}
+
 // ------------------------------------------------------------------------------------
 
 // KeyGenRequest represents a request that can be sent to emitter broker
@@ -98,7 +161,7 @@ type presenceResponse struct {
 	Request uint16          `json:"req,omitempty"`
 	Event   string          `json:"event"`
 	Channel string          `json:"channel"`
-	Time    int             `json:"time"`
+	Time    int64           `json:"time"`
 	Who     json.RawMessage `json:"who"`
 }
 
@@ -107,11 +170,97 @@ func (r *presenceResponse) RequestID() uint16 {
 	return r.Request
 }
 
-// PresenceEvent  represents a response from emitter broker which contains
-// presence state or a join/leave notification.
+// event decodes the presence event carried by the message.
+func (r *presenceResponse) event() (PresenceEvent, error) {
+	ev := PresenceEvent{
+		Request: r.Request,
+		Channel: r.Channel,
+		Time:    time.Unix(r.Time, 0),
+	}
+
+	if r.Event != "" { // The status is the default
+		if err := ev.Event.UnmarshalText([]byte(r.Event)); err != nil {
+			return ev, err
+		}
+	}
+
+	who, err := decodeWho(r.Who)
+	ev.Who = who
+	return ev, err
+}
+
+// decodeWho decodes the "who" field of a presence message, which is either a single
+// presence information or an array of them.
+func decodeWho(raw json.RawMessage) ([]PresenceInfo, error) {
+	trimmed := bytes.TrimSpace(raw)
+	switch {
+	case len(trimmed) == 0, bytes.Equal(trimmed, []byte("null")):
+		return make([]PresenceInfo, 0), nil
+	case trimmed[0] == '[':
+		who := make([]PresenceInfo, 0)
+		err := json.Unmarshal(trimmed, &who)
+		return who, err
+	}
+
+	var who PresenceInfo
+	if err := json.Unmarshal(trimmed, &who); err != nil {
+		return nil, err
+	}
+	return []PresenceInfo{who}, nil
+}
+
+// PresenceEventType represents the type of a presence event.
+type PresenceEventType uint8
+
+// Various presence event types
+const (
+	PresenceStatus      PresenceEventType = iota // The clients subscribed, in response to a presence request
+	PresenceSubscribe                            // A client subscribed to the channel
+	PresenceUnsubscribe                          // A client unsubscribed from the channel
+)
+
+// String returns the name of the presence event type, as sent by the broker.
+func (t PresenceEventType) String() string {
+	switch t {
+	case PresenceStatus:
+		return "status"
+	case PresenceSubscribe:
+		return "subscribe"
+	case PresenceUnsubscribe:
+		return "unsubscribe"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the presence event type as its name.
+func (t PresenceEventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText decodes the presence event type from its name.
+func (t *PresenceEventType) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "status":
+		*t = PresenceStatus
+	case "subscribe":
+		*t = PresenceSubscribe
+	case "unsubscribe":
+		*t = PresenceUnsubscribe
+	default:
+		return fmt.Errorf("emitter: unknown presence event '%s'", text)
+	}
+	return nil
+}
+
+// PresenceEvent represents a response from emitter broker which contains the clients
+// subscribed to a channel, or a notification of a client subscribing or unsubscribing.
 type PresenceEvent struct {
-	presenceResponse
-	Who []PresenceInfo
+	Request uint16            `json:"req,omitempty"` // The identifier of the request, for a status
+	Event   PresenceEventType `json:"event"`         // The type of the event
+	Channel string            `json:"channel"`       // The channel, as normalized by the broker
+	Time    time.Time         `json:"time"`          // The time of the event, to the second
+	Who     []PresenceInfo    `json:"who"`           // The clients subscribed, or the client which subscribed or unsubscribed
 }
 
 // RequestID returns the request ID for the response.
@@ -4,6 +4,7 @@
 package emitter
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -16,6 +17,47 @@ func TestUUID(t *testing.T) {
 	assert.Len(t, id, 36)
 }
 
+func TestRestoreError(t *testing.T) {
+	var err error = RestoreError{Channel: "a/", Err: ErrTimeout}
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	var restore RestoreError
+	assert.True(t, errors.As(err, &restore))
+	assert.Equal(t, "a/", restore.Channel)
+}
+
+func TestPresenceEventType(t *testing.T) {
+	for _, typ := range []PresenceEventType{PresenceStatus, PresenceSubscribe, PresenceUnsubscribe} {
+		text, err := typ.MarshalText()
+		assert.NoError(t, err)
+
+		var decoded PresenceEventType
+		assert.NoError(t, decoded.UnmarshalText(text))
+		assert.Equal(t, typ, decoded)
+	}
+
+	var typ PresenceEventType
+	assert.Error(t, typ.UnmarshalText([]byte("join")))
+	assert.Equal(t, "unknown", PresenceEventType(9).String())
+}
+
+func TestDecodeWho(t *testing.T) {
+	for raw, expect := range map[string][]PresenceInfo{
+		``:                          {},
+		`null`:                      {},
+		`[]`:                        {},
+		`{"id":"A","username":"a"}`: {{ID: "A", Username: "a"}},
+		` [{"id":"A"}, {"id":"B"}]`: {{ID: "A"}, {ID: "B"}},
+	} {
+		who, err := decodeWho([]byte(raw))
+		assert.NoError(t, err)
+		assert.Equal(t, expect, who)
+	}
+
+	_, err := decodeWho([]byte(`"A"`))
+	assert.Error(t, err)
+}
+
 type message struct {
 	duplicate bool
 	qos       byte